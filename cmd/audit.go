@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/audit"
+	"github.com/ustclug/podzol/pkg/format"
+)
+
+var (
+	auditQueryUser     int
+	auditQueryApp      string
+	auditQueryAction   string
+	auditQueryIdentity string
+	auditQuerySince    time.Duration
+	auditQueryJSON     bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log",
+	Long:  `Verify and query the audit log of the server. Without FILE, the audit-log path of the configuration is used.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [FILE]",
+	Short: "Verify the hash chain of the audit log",
+	Long: `Verify that no entry of the audit log has been modified, removed or reordered, using the audit-key of the configuration.
+The last entry is printed, to be compared with a copy kept elsewhere, as removing entries at the end cannot be detected from the file alone.`,
+	RunE: auditVerifyRunE,
+	Args: cobra.MaximumNArgs(1),
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:   "query [FILE] [--user USER] [--app APPLICATION] [--action ACTION] [--identity NAME] [--since DURATION]",
+	Short: "Search the audit log",
+	Long:  `Print entries of the audit log matching all given filters`,
+	RunE:  auditQueryRunE,
+	Args:  cobra.MaximumNArgs(1),
+}
+
+// openAuditLog opens the audit log given on the command line, or the one of the configuration.
+func openAuditLog(args []string) (*os.File, error) {
+	if len(args) == 1 {
+		return os.Open(args[0])
+	}
+	path := viper.GetString("audit-log")
+	if path == "" {
+		return nil, fmt.Errorf("audit-log is not configured")
+	}
+	return os.Open(path)
+}
+
+func auditVerifyRunE(cmd *cobra.Command, args []string) error {
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	f, err := openAuditLog(args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	last, err := audit.Verify(f, []byte(viper.GetString("audit-key")))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%d entries verified\n", last.Seq)
+	if last.Seq > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "last entry: seq %d, time %s, hash %s\n", last.Seq, last.Time.Format(time.RFC3339), last.Hash)
+	}
+	return nil
+}
+
+func auditQueryRunE(cmd *cobra.Command, args []string) error {
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	f, err := openAuditLog(args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var since time.Time
+	if auditQuerySince > 0 {
+		since = time.Now().Add(-auditQuerySince)
+	}
+	userSet := cmd.Flags().Changed("user")
+	var entries []audit.Entry
+	err = audit.Read(f, func(e audit.Entry) error {
+		switch {
+		case userSet && e.User != auditQueryUser,
+			auditQueryApp != "" && e.App != auditQueryApp,
+			auditQueryAction != "" && e.Action != auditQueryAction,
+			auditQueryIdentity != "" && e.Identity != auditQueryIdentity,
+			e.Time.Before(since):
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if auditQueryJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return format.ListAuditEntries(cmd.OutOrStdout(), entries)
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.AddCommand(auditQueryCmd)
+
+	flags := auditQueryCmd.Flags()
+	flags.IntVar(&auditQueryUser, "user", 0, "only show entries of this user")
+	flags.StringVar(&auditQueryApp, "app", "", "only show entries of this application")
+	flags.StringVar(&auditQueryAction, "action", "", "only show entries of this action")
+	flags.StringVar(&auditQueryIdentity, "identity", "", "only show entries of this API key")
+	flags.DurationVar(&auditQuerySince, "since", 0, "only show entries newer than this")
+	flags.BoolVar(&auditQueryJSON, "json", false, "print entries as JSON lines")
+}
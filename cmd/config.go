@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/ustclug/podzol/pkg/config"
 )
 
@@ -16,6 +17,17 @@ var defaultconfigCmd = &cobra.Command{
 	},
 }
 
+// loadOptionalConfig reads the configuration file, for the API key and listen address used by client commands
+// and the settings of the server used by others, such as the audit log. Without a configuration file, the defaults are used.
+func loadOptionalConfig() error {
+	if err := config.Load(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(defaultconfigCmd)
 
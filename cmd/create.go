@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -10,8 +12,13 @@ import (
 	"github.com/ustclug/podzol/pkg/format"
 )
 
+var (
+	createWait         bool
+	createPollInterval time.Duration
+)
+
 var createCmd = &cobra.Command{
-	Use:   "create TOKEN APPLICATION IMAGE HOSTNAME [timeout]",
+	Use:   "create [--wait] TOKEN APPLICATION IMAGE HOSTNAME [timeout]",
 	Short: "Create a new container",
 	Long:  `Create a new container with the given arguments. If timeout is not specified, it defaults to a minute.`,
 	RunE:  createRunE,
@@ -51,6 +58,9 @@ func createRunE(cmd *cobra.Command, args []string) error {
 		Hostname: hostname,
 		Lifetime: timeout,
 	}
+	if createWait {
+		return createAndWait(cmd, c, opts)
+	}
 	data, err := c.Create(opts)
 	if err != nil {
 		return err
@@ -58,6 +68,41 @@ func createRunE(cmd *cobra.Command, args []string) error {
 	return format.ShowContainer(cmd.OutOrStdout(), data)
 }
 
+// createAndWait creates the container asynchronously and polls the job until it finishes.
+func createAndWait(cmd *cobra.Command, c *client.Client, opts docker.ContainerOptions) error {
+	job, err := c.CreateAsync(opts)
+	if err != nil {
+		return err
+	}
+	phase := ""
+	for {
+		if job.Phase != phase {
+			phase = job.Phase
+			fmt.Fprintf(cmd.ErrOrStderr(), "Job %s: %s\n", job.ID, phase)
+		}
+		if job.Done() {
+			break
+		}
+		time.Sleep(createPollInterval)
+		job, err = c.Job(job.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if job.Container != nil {
+		format.ShowContainer(cmd.OutOrStdout(), *job.Container)
+	}
+	if job.Phase == docker.PhaseFailed {
+		return errors.New(job.Error)
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(createCmd)
+
+	flags := createCmd.Flags()
+	flags.BoolVarP(&createWait, "wait", "w", false, "create asynchronously and wait for the container to be ready")
+	flags.DurationVar(&createPollInterval, "interval", time.Second, "polling interval for --wait")
 }
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+	"golang.org/x/term"
+)
+
+var (
+	execOpts        docker.ExecOptions
+	execInteractive bool
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec [-i] [-t] { USER | TOKEN } APPLICATION -- COMMAND [ARG...]",
+	Short: "Run a command in a container",
+	Long:  `Run a command in a container, such as an interactive shell. Requires an API key with the admin scope.`,
+	RunE:  execRunE,
+}
+
+func execRunE(cmd *cobra.Command, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("bad number of arguments")
+	}
+	user, err := parseUser(args[0])
+	if err != nil {
+		return err
+	}
+	app := args[1]
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	opts := docker.ContainerOptions{
+		User:    user,
+		AppName: app,
+	}
+	execOpts.Cmd = args[2:]
+	code, err := execRun(opts, execOpts)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+// execRun runs the command and returns its exit code, restoring the local terminal before returning.
+func execRun(opts docker.ContainerOptions, execOpts docker.ExecOptions) (int, error) {
+	stdinFd, stdoutFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if execOpts.Tty && term.IsTerminal(stdoutFd) {
+		if cols, rows, err := term.GetSize(stdoutFd); err == nil {
+			execOpts.Rows, execOpts.Cols = uint(rows), uint(cols)
+		}
+	}
+
+	c := client.NewClient(viper.GetViper())
+	conn, err := c.Exec(opts, execOpts)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var stdin io.Reader
+	if execInteractive {
+		stdin = os.Stdin
+	}
+	if execOpts.Tty {
+		if term.IsTerminal(stdinFd) {
+			state, err := term.MakeRaw(stdinFd)
+			if err != nil {
+				return 0, err
+			}
+			defer term.Restore(stdinFd, state)
+		}
+		if term.IsTerminal(stdoutFd) {
+			stop := notifyResize(func() {
+				if cols, rows, err := term.GetSize(stdoutFd); err == nil {
+					_ = conn.Resize(uint(rows), uint(cols))
+				}
+			})
+			defer stop()
+		}
+	}
+	return conn.Stream(stdin, os.Stdout, os.Stderr)
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	flags := execCmd.Flags()
+	flags.BoolVarP(&execInteractive, "interactive", "i", false, "keep stdin open")
+	flags.BoolVarP(&execOpts.Tty, "tty", "t", false, "allocate a pseudo-TTY")
+	flags.StringVarP(&execOpts.User, "exec-user", "u", "", "user to run the command as inside the container")
+	flags.StringArrayVarP(&execOpts.Env, "env", "e", nil, "set environment variables")
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cmd
+
+// notifyResize is not supported on this platform, so the terminal keeps its initial size.
+func notifyResize(f func()) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/format"
+)
+
+var extendCmd = &cobra.Command{
+	Use:   "extend { USER | TOKEN } APPLICATION DURATION",
+	Short: "Extend the deadline of a container",
+	Long:  `Move the deadline of a container by DURATION (e.g. 30m), which may be negative to shorten it`,
+	RunE:  extendRunE,
+}
+
+func extendRunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("bad number of arguments")
+	}
+	user, err := parseUser(args[0])
+	if err != nil {
+		return err
+	}
+	app := args[1]
+	by, err := time.ParseDuration(args[2])
+	if err != nil {
+		return err
+	}
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	opts := docker.ContainerOptions{
+		User:    user,
+		AppName: app,
+	}
+	c := client.NewClient(viper.GetViper())
+	info, err := c.Inspect(opts)
+	if err != nil {
+		return err
+	}
+	data, err := c.Extend(info.Name, info.Deadline.Add(by))
+	if err != nil {
+		return err
+	}
+	return format.ShowContainer(cmd.OutOrStdout(), data)
+}
+
+func init() {
+	rootCmd.AddCommand(extendCmd)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/format"
+)
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "List catalog images",
+	Long:  `List the images configured for applications, with their local ID and digest.`,
+	RunE:  imagesRunE,
+	Args:  cobra.NoArgs,
+
+	SilenceUsage: true,
+}
+
+var imagesPullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Pull catalog images",
+	Long:  `Pull all images configured for applications and report their digests.`,
+	RunE:  imagesPullRunE,
+	Args:  cobra.NoArgs,
+
+	SilenceUsage: true,
+}
+
+var imagesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unused images",
+	Long:  `Remove stale versions of catalog images that are not used by any container.`,
+	RunE:  imagesPruneRunE,
+	Args:  cobra.NoArgs,
+
+	SilenceUsage: true,
+}
+
+func imagesRunE(cmd *cobra.Command, args []string) error {
+	c := client.NewClient(viper.GetViper())
+	data, err := c.Images()
+	if err != nil {
+		return err
+	}
+	return format.ListImages(cmd.OutOrStdout(), data)
+}
+
+func imagesPullRunE(cmd *cobra.Command, args []string) error {
+	c := client.NewClient(viper.GetViper())
+	data, err := c.PullImages()
+	if err != nil {
+		return err
+	}
+	return format.ListImages(cmd.OutOrStdout(), data)
+}
+
+func imagesPruneRunE(cmd *cobra.Command, args []string) error {
+	c := client.NewClient(viper.GetViper())
+	data, err := c.PruneImages()
+	if err != nil {
+		return err
+	}
+	return format.ListImages(cmd.OutOrStdout(), data)
+}
+
+func init() {
+	rootCmd.AddCommand(imagesCmd)
+	imagesCmd.AddCommand(imagesPullCmd)
+	imagesCmd.AddCommand(imagesPruneCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/format"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect { USER | TOKEN } APPLICATION",
+	Short: "Show details of a container",
+	Long:  `Show details of a container, including its network and egress policy`,
+	RunE:  inspectRunE,
+}
+
+func inspectRunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("bad number of arguments")
+	}
+	user, err := parseUser(args[0])
+	if err != nil {
+		return err
+	}
+	app := args[1]
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	opts := docker.ContainerOptions{
+		User:    user,
+		AppName: app,
+	}
+	c := client.NewClient(viper.GetViper())
+	data, err := c.Inspect(opts)
+	if err != nil {
+		return err
+	}
+	return format.ShowContainer(cmd.OutOrStdout(), data)
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+}
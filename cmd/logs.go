@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+var logOpts = docker.LogOptions{
+	Stdout: true,
+	Stderr: true,
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [-f] { USER | TOKEN } APPLICATION",
+	Short: "Show container logs",
+	Long:  `Show the logs of a container`,
+	RunE:  logsRunE,
+}
+
+func logsRunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("bad number of arguments")
+	}
+	user, err := parseUser(args[0])
+	if err != nil {
+		return err
+	}
+	app := args[1]
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	opts := docker.ContainerOptions{
+		User:    user,
+		AppName: app,
+	}
+	c := client.NewClient(viper.GetViper())
+	r, err := c.Logs(opts, logOpts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = stdcopy.StdCopy(cmd.OutOrStdout(), cmd.ErrOrStderr(), r)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	flags := logsCmd.Flags()
+	flags.BoolVarP(&logOpts.Follow, "follow", "f", false, "follow log output")
+	flags.StringVarP(&logOpts.Tail, "tail", "n", "all", "number of lines to show from the end of the logs")
+	flags.StringVar(&logOpts.Since, "since", "", "show logs since timestamp or relative duration (e.g. 10m)")
+	flags.BoolVar(&logOpts.Stdout, "stdout", true, "show stdout")
+	flags.BoolVar(&logOpts.Stderr, "stderr", true, "show stderr")
+}
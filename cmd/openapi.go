@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/ustclug/podzol/pkg/server"
+)
+
+var openapiCmd = &cobra.Command{
+	Use:   "openapi",
+	Short: "Print the OpenAPI document of the API",
+	Long:  `Print the OpenAPI 3 document of the API, as served at /openapi.json. It fails if the document cannot be generated from the types of the API.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		b, err := server.OpenAPI()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(b))
+		return nil
+	},
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(openapiCmd)
+}
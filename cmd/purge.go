@@ -20,6 +20,9 @@ func purgeRunE(cmd *cobra.Command, args []string) error {
 
 	c := client.NewClient(viper.GetViper())
 	infos, err := c.Purge()
+	if infos == nil && err != nil {
+		return err
+	}
 
 	w := cmd.OutOrStdout()
 	format.ListContainers(w, infos)
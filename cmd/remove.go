@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -11,44 +12,95 @@ import (
 	"github.com/ustclug/podzol/pkg/format"
 )
 
+var (
+	removeUser   string
+	removeApp    string
+	removeLabels []string
+	removeDryRun bool
+)
+
 var removeCmd = &cobra.Command{
-	Use:     "remove { USER | TOKEN } APPLICATION",
+	Use:     "remove { { USER | TOKEN } APPLICATION | [--user USER] [--app APPLICATION] [--label KEY[=VALUE]]... } [--dry-run]",
 	Aliases: []string{"rm"},
-	Short:   "Remove a container",
-	Long:    `Remove a container`,
-	RunE:    removeRunE,
+	Short:   "Remove containers",
+	Long: `Remove a container, or all containers matching the filters.
+
+Label selectors match the Docker labels of containers, including those of their images.
+With --dry-run, the containers are only listed.`,
+	RunE: removeRunE,
 }
 
 func removeRunE(cmd *cobra.Command, args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("bad number of arguments")
-	}
-	user, err := strconv.Atoi(args[0])
-	if err != nil {
-		token := args[0]
-		user, err = format.ParseUserID(token)
+	flags := cmd.Flags()
+	filtered := flags.Changed("user") || flags.Changed("app") || flags.Changed("label")
+	var filter docker.ContainerFilter
+	switch {
+	case len(args) == 2 && !filtered:
+		user, err := parseUser(args[0])
 		if err != nil {
 			return err
 		}
+		filter.User, filter.App = user, args[1]
+	case len(args) == 0 && filtered:
+		if removeUser != "" {
+			user, err := parseUser(removeUser)
+			if err != nil {
+				return err
+			}
+			filter.User = user
+		}
+		filter.App = removeApp
+		filter.Labels = removeLabels
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("bad number of arguments")
 	}
-	app := args[1]
 
 	// Arguments validated
 	cmd.SilenceUsage = true
 
-	opts := docker.ContainerOptions{
-		User:    user,
-		AppName: app,
-	}
 	c := client.NewClient(viper.GetViper())
-	err = c.Remove(opts)
+	if !filtered && !removeDryRun {
+		err := c.Remove(docker.ContainerOptions{User: filter.User, AppName: filter.App})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "OK")
+		return nil
+	}
+
+	resp, err := c.RemoveAll(filter, removeDryRun)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(cmd.OutOrStdout(), "OK")
+	if err := format.ListRemoveResults(cmd.OutOrStdout(), resp.DryRun, resp.Results); err != nil {
+		return err
+	}
+	for _, r := range resp.Results {
+		if r.Err != nil {
+			return errors.New("some containers could not be removed")
+		}
+	}
 	return nil
 }
 
+// parseUser accepts either a numeric user ID or a token.
+func parseUser(arg string) (int, error) {
+	user, err := strconv.Atoi(arg)
+	if err != nil {
+		return format.ParseUserID(arg)
+	}
+	return user, nil
+}
+
 func init() {
 	rootCmd.AddCommand(removeCmd)
+
+	flags := removeCmd.Flags()
+	flags.StringVar(&removeUser, "user", "", "remove the containers of this user, by ID or token")
+	flags.StringVar(&removeApp, "app", "", "remove the containers of this application")
+	flags.StringArrayVar(&removeLabels, "label", nil, "remove the containers with this Docker label, as KEY or KEY=VALUE (repeatable)")
+	flags.BoolVar(&removeDryRun, "dry-run", false, "only list the containers that would be removed")
 }
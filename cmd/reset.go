@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/format"
+)
+
+var resetCmd = &cobra.Command{
+	Use:   "reset { USER | TOKEN } APPLICATION",
+	Short: "Reset a container",
+	Long:  `Recreate a container from its image, keeping its name, hostname, port and deadline`,
+	RunE:  resetRunE,
+}
+
+func resetRunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("bad number of arguments")
+	}
+	user, err := parseUser(args[0])
+	if err != nil {
+		return err
+	}
+	app := args[1]
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	opts := docker.ContainerOptions{
+		User:    user,
+		AppName: app,
+	}
+	c := client.NewClient(viper.GetViper())
+	data, err := c.Reset(opts)
+	if err != nil {
+		return err
+	}
+	return format.ShowContainer(cmd.OutOrStdout(), data)
+}
+
+func init() {
+	rootCmd.AddCommand(resetCmd)
+}
@@ -17,7 +17,11 @@ var rootCmd = &cobra.Command{
 		if overrideConfigFile != "" {
 			viper.SetConfigFile(overrideConfigFile)
 		}
-		return nil
+		// The default configuration is generated from the defaults alone
+		if cmd == defaultconfigCmd {
+			return nil
+		}
+		return loadOptionalConfig()
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return cmd.Help()
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestClientConfig checks that client commands read the API key and the Unix socket
+// of the configuration file, while defaultconfig writes the defaults.
+func TestClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "podzol.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authorization string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("[]"))
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	configFile := filepath.Join(dir, "config.yaml")
+	config := "listen-addr: unix:" + socket + "\napi-key: secret\n"
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+
+	output := filepath.Join(dir, "config.example.yaml")
+	rootCmd.SetArgs([]string{"--config", configFile, "defaultconfig", "-o", output})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") || strings.Contains(string(b), socket) {
+		t.Errorf("defaultconfig wrote the settings of %s:\n%s", configFile, b)
+	}
+
+	rootCmd.SetArgs([]string{"--config", configFile, "list"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("list sent authorization %q, want %q", authorization, "Bearer secret")
+	}
+}
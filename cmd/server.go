@@ -1,12 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/ustclug/podzol/pkg/config"
+	"github.com/ustclug/podzol/pkg/logging"
 	"github.com/ustclug/podzol/pkg/server"
+	"github.com/ustclug/podzol/pkg/systemd"
 )
 
 var serverCmd = &cobra.Command{
@@ -25,23 +33,86 @@ func serverRunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if err := logging.Setup(viper.GetViper()); err != nil {
+		return err
+	}
+
 	s, err := server.NewServer(viper.GetViper())
 	if err != nil {
 		return err
 	}
 
+	// Listen first, so that a handoff fails early if a new address is in use
+	if err := s.Listen(); err != nil {
+		return err
+	}
 	err = s.DockerInit(cmd.Context())
 	if err != nil {
 		return err
 	}
-	errCh := make(chan error, 1)
+	errCh := make(chan error, 5)
 	go func() {
 		errCh <- s.Run()
 	}()
 	go func() {
 		errCh <- s.RunHTTP()
 	}()
-	return <-errCh
+	if s.MetricsEnabled() {
+		go func() {
+			errCh <- s.RunMetrics()
+		}()
+	}
+	if s.SSHEnabled() {
+		go func() {
+			errCh <- s.RunSSH()
+		}()
+	}
+	if s.GRPCEnabled() {
+		go func() {
+			errCh <- s.RunGRPC()
+		}()
+	}
+	if err := s.Ready(); err != nil {
+		slog.Warn("failed to notify the previous process", "error", err)
+	}
+	// After a handoff, this process replaces the previous one as the main process of the service
+	if err := systemd.Notify(fmt.Sprintf("%s\nMAINPID=%d", systemd.Ready, os.Getpid())); err != nil {
+		slog.Warn("failed to notify systemd", "error", err)
+	}
+	watchdogCtx, stopWatchdog := context.WithCancel(cmd.Context())
+	defer stopWatchdog()
+	go systemd.RunWatchdog(watchdogCtx)
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	upgrade := make(chan os.Signal, 1)
+	notifyUpgrade(upgrade)
+	var runErr error
+loop:
+	for {
+		select {
+		case runErr = <-errCh:
+			_ = systemd.Notify(systemd.Stopping)
+			break loop
+		case <-ctx.Done():
+			slog.Info("shutting down")
+			_ = systemd.Notify(systemd.Stopping)
+			break loop
+		case <-upgrade:
+			if err := s.Handoff(); err != nil {
+				slog.Error("handoff failed", "error", err)
+				continue
+			}
+			slog.Info("shutting down after handoff")
+			break loop
+		}
+	}
+	// A second signal terminates immediately
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout())
+	defer cancel()
+	return errors.Join(runErr, s.Shutdown(shutdownCtx))
 }
 
 func init() {
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cmd
+
+import "os"
+
+// notifyUpgrade is not supported on this platform, so the server can only be restarted.
+func notifyUpgrade(ch chan<- os.Signal) {}
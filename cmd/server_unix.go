@@ -0,0 +1,14 @@
+//go:build unix
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyUpgrade relays SIGUSR2, which asks the server to hand off its listeners to a new process.
+func notifyUpgrade(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR2)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/format"
+	"golang.org/x/term"
+)
+
+var (
+	topOpts     docker.ContainerOptions
+	topInterval time.Duration
+	topSort     string
+	topOnce     bool
+)
+
+// topSortKeys order container stats, largest first except for names.
+var topSortKeys = map[string]func(a, b docker.ContainerStats) bool{
+	"name": func(a, b docker.ContainerStats) bool { return a.Name < b.Name },
+	"cpu":  func(a, b docker.ContainerStats) bool { return a.CPUPercent > b.CPUPercent },
+	"mem":  func(a, b docker.ContainerStats) bool { return a.MemoryUsage > b.MemoryUsage },
+	"net":  func(a, b docker.ContainerStats) bool { return a.NetworkRx+a.NetworkTx > b.NetworkRx+b.NetworkTx },
+	"pids": func(a, b docker.ContainerStats) bool { return a.PIDs > b.PIDs },
+}
+
+var topCmd = &cobra.Command{
+	Use:   "top [--user USER] [--app APPLICATION] [--sort KEY]",
+	Short: "Show resource usage of containers",
+	Long:  `Show CPU, memory, network and PID usage of running containers, refreshing periodically`,
+	RunE:  topRunE,
+}
+
+func topRunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("bad number of arguments")
+	}
+	less, ok := topSortKeys[topSort]
+	if !ok {
+		return fmt.Errorf("invalid sort key %q", topSort)
+	}
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	c := client.NewClient(viper.GetViper())
+	w := cmd.OutOrStdout()
+	redraw := !topOnce && term.IsTerminal(int(os.Stdout.Fd()))
+	for {
+		data, err := c.Stats(topOpts)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(data, func(i, j int) bool { return less(data[i], data[j]) })
+		if redraw {
+			// Move the cursor home and clear the screen
+			fmt.Fprint(w, "\033[H\033[2J")
+		}
+		if err := format.ListStats(w, data); err != nil {
+			return err
+		}
+		if topOnce {
+			return nil
+		}
+		time.Sleep(topInterval)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(topCmd)
+
+	keys := make([]string, 0, len(topSortKeys))
+	for k := range topSortKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	flags := topCmd.Flags()
+	flags.IntVar(&topOpts.User, "user", 0, "only show containers of this user")
+	flags.StringVar(&topOpts.AppName, "app", "", "only show containers of this application")
+	flags.DurationVarP(&topInterval, "interval", "i", 2*time.Second, "refresh interval")
+	flags.StringVarP(&topSort, "sort", "s", "cpu", "sort by one of: "+strings.Join(keys, ", "))
+	flags.BoolVar(&topOnce, "once", false, "print once and exit")
+}
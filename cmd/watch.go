@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/client"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/format"
+)
+
+var (
+	watchOpts docker.ContainerOptions
+	watchJSON bool
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [--user USER] [--app APPLICATION] [--json]",
+	Short: "Print container lifecycle events",
+	Long:  `Print lifecycle events of containers as they happen, until interrupted`,
+	RunE:  watchRunE,
+}
+
+func watchRunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("bad number of arguments")
+	}
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	c := client.NewClient(viper.GetViper())
+	events, err := c.Watch(cmd.Context(), watchOpts)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	for e := range events {
+		if watchJSON {
+			err = enc.Encode(e)
+		} else {
+			err = format.PrintEvent(cmd.OutOrStdout(), e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.Context().Err() != nil {
+		return nil
+	}
+	return errors.New("event stream closed by the server")
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	flags := watchCmd.Flags()
+	flags.IntVar(&watchOpts.User, "user", 0, "only show events of this user")
+	flags.StringVar(&watchOpts.AppName, "app", "", "only show events of this application")
+	flags.BoolVar(&watchJSON, "json", false, "print events as JSON lines")
+}
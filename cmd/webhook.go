@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/webhook"
+)
+
+var (
+	webhookListenAddr   string
+	webhookListenSecret string
+	webhookListenStatus int
+
+	webhookTestEvent  string
+	webhookTestURL    string
+	webhookTestSecret string
+)
+
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Test webhook delivery",
+	Long:  `Send test events to webhook targets, and receive them with a local stand-in endpoint`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var webhookListenCmd = &cobra.Command{
+	Use:   "listen [-l listen] [--secret SECRET] [--status CODE]",
+	Short: "Print webhook requests received on a local address",
+	Long:  `Run a stand-in webhook endpoint that prints every event it receives and checks its signature`,
+	RunE:  webhookListenRunE,
+	Args:  cobra.NoArgs,
+}
+
+var webhookTestCmd = &cobra.Command{
+	Use:   "test [--event EVENT] [--url URL --secret SECRET]",
+	Short: "Send a test event to webhook targets",
+	Long:  `Send a sample event once to each configured webhook target, or to the given URL, and report the outcome`,
+	RunE:  webhookTestRunE,
+	Args:  cobra.NoArgs,
+}
+
+func webhookListenRunE(cmd *cobra.Command, args []string) error {
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	w := cmd.OutOrStdout()
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signature := "unsigned"
+		if sig := r.Header.Get(webhook.SignatureHeader); sig != "" {
+			signature = "invalid signature"
+			if webhookListenSecret == "" {
+				signature = "signed (no secret to verify)"
+			} else if webhook.Verify(webhookListenSecret, body, sig) {
+				signature = "valid signature"
+			}
+		}
+		fmt.Fprintf(w, "%s %s %s delivery=%s %s\n", time.Now().Format(time.TimeOnly), r.Method,
+			r.Header.Get(webhook.EventHeader), r.Header.Get(webhook.DeliveryHeader), signature)
+		var indented bytes.Buffer
+		if json.Indent(&indented, body, "", "  ") == nil {
+			body = indented.Bytes()
+		}
+		fmt.Fprintf(w, "%s\n\n", body)
+		rw.WriteHeader(webhookListenStatus)
+	})
+	fmt.Fprintf(cmd.ErrOrStderr(), "Listening on http://%s/\n", webhookListenAddr)
+	return http.ListenAndServe(webhookListenAddr, handler)
+}
+
+func webhookTestRunE(cmd *cobra.Command, args []string) error {
+	found := false
+	for _, e := range docker.Events {
+		found = found || e == webhookTestEvent
+	}
+	if !found {
+		return fmt.Errorf("unknown event %q", webhookTestEvent)
+	}
+
+	// Arguments validated
+	cmd.SilenceUsage = true
+
+	var targets []webhook.Target
+	if webhookTestURL != "" {
+		targets = []webhook.Target{{URL: webhookTestURL, Secret: webhookTestSecret, Timeout: 10 * time.Second}}
+	} else {
+		var err error
+		targets, err = webhook.LoadTargets(viper.GetViper())
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("no webhooks configured")
+		}
+	}
+
+	p := webhook.NewPayload(docker.Event{
+		Type: webhookTestEvent,
+		Time: time.Now().UTC(),
+		Container: docker.ContainerInfo{
+			Name:     "podzol_0_test_1",
+			Hostname: "test.example.com",
+			Deadline: time.Now().Add(time.Hour),
+			App:      "test",
+			State:    docker.StateRunning,
+		},
+	})
+	failed := 0
+	for _, t := range targets {
+		if err := webhook.Deliver(cmd.Context(), t, p); err != nil {
+			failed++
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %v\n", t.URL, err)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: ok\n", t.URL)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deliveries failed", failed, len(targets))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(webhookCmd)
+	webhookCmd.AddCommand(webhookListenCmd)
+	webhookCmd.AddCommand(webhookTestCmd)
+
+	flags := webhookListenCmd.Flags()
+	flags.StringVarP(&webhookListenAddr, "listen", "l", "127.0.0.1:9996", "listen address")
+	flags.StringVar(&webhookListenSecret, "secret", "", "secret to verify signatures with")
+	flags.IntVar(&webhookListenStatus, "status", http.StatusNoContent, "status code to respond with, to exercise retries")
+
+	flags = webhookTestCmd.Flags()
+	flags.StringVar(&webhookTestEvent, "event", docker.EventReady, "type of the test event")
+	flags.StringVar(&webhookTestURL, "url", "", "send to this URL instead of the configured targets")
+	flags.StringVar(&webhookTestSecret, "secret", "", "secret to sign the test event with, with --url")
+}
@@ -0,0 +1,246 @@
+// Package audit implements a tamper-evident audit log of management actions.
+//
+// The log is a file of JSON lines. Each entry carries the hash of the previous entry,
+// and its own HMAC over its content with a secret key, so that modifying, removing or
+// reordering entries breaks the chain and is detected by Verify. Removing entries at the
+// end is only detected by comparing the last entry with a copy kept elsewhere.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionCreate      = "create"
+	ActionRemove      = "remove"
+	ActionRestart     = "restart"
+	ActionReset       = "reset"
+	ActionExtend      = "extend"
+	ActionPurge       = "purge"
+	ActionExec        = "exec"
+	ActionImagesPull  = "images.pull"
+	ActionImagesPrune = "images.prune"
+)
+
+// Outcomes of recorded actions.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Entry is a record of the audit log.
+type Entry struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+
+	Action string `json:"action"`
+	// Name of the API key, or "anonymous"
+	Identity string `json:"identity"`
+	// Remote address of the request, and the X-Forwarded-For header if any
+	Source       string `json:"source,omitempty"`
+	ForwardedFor string `json:"forwarded_for,omitempty"`
+
+	User        int    `json:"user,omitempty"`
+	App         string `json:"app,omitempty"`
+	Container   string `json:"container,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	// Action-specific details, such as the command of an exec
+	Detail string `json:"detail,omitempty"`
+
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// computeHash returns the HMAC of the entry with key, which covers all fields except Hash itself.
+func (e Entry) computeHash(key []byte) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ErrNoKey is returned by Open and Verify without a key.
+var ErrNoKey = errors.New("audit key is not set")
+
+// ErrTruncated is returned by Append if the file has shrunk since it was last read.
+var ErrTruncated = errors.New("audit log has been truncated")
+
+// Log is an append-only audit log file.
+// Several processes may append to the same file, e.g. the old and new server during a handoff.
+type Log struct {
+	mu   sync.Mutex
+	f    *os.File
+	key  []byte
+	seq  uint64
+	prev string
+	// Size of the file when last read or written by this process
+	size int64
+}
+
+// Open opens the audit log at path for appending with key, creating it if necessary.
+// The chain is continued from the last entry. The existing entries are not verified.
+func Open(path string, key []byte) (*Log, error) {
+	if len(key) == 0 {
+		return nil, ErrNoKey
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{f: f, key: key}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer unlockFile(f)
+	if err := l.catchUp(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// catchUp continues the chain from entries appended by other processes since the file was last read.
+// The caller must hold the file lock.
+func (l *Log) catchUp() error {
+	fi, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == l.size {
+		return nil
+	}
+	if fi.Size() < l.size {
+		return fmt.Errorf("%w: %d bytes, expected at least %d", ErrTruncated, fi.Size(), l.size)
+	}
+	err = Read(io.NewSectionReader(l.f, l.size, fi.Size()-l.size), func(e Entry) error {
+		l.seq, l.prev = e.Seq, e.Hash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.size = fi.Size()
+	return nil
+}
+
+// Append completes the entry with its sequence number, time and hashes, and writes it to the log.
+// Appending to a nil Log does nothing, so that auditing can be disabled.
+func (l *Log) Append(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := lockFile(l.f); err != nil {
+		return err
+	}
+	defer unlockFile(l.f)
+	if err := l.catchUp(); err != nil {
+		return err
+	}
+
+	e.Seq = l.seq + 1
+	e.Time = time.Now().UTC()
+	e.Prev = l.prev
+	hash, err := e.computeHash(l.key)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := l.f.Write(append(b, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.seq, l.prev = e.Seq, e.Hash
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// Read calls f for each entry of the log, in order.
+func Read(r io.Reader, f func(Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ErrBrokenChain is returned by Verify if the log has been tampered with.
+var ErrBrokenChain = errors.New("audit log chain is broken")
+
+// Verify checks the hash chain of the log with key and returns its last entry, if any.
+// The sequence number of the last entry is the number of entries.
+func Verify(r io.Reader, key []byte) (Entry, error) {
+	if len(key) == 0 {
+		return Entry{}, ErrNoKey
+	}
+	var last Entry
+	n := 0
+	prev := ""
+	err := Read(r, func(e Entry) error {
+		n++
+		hash, err := e.computeHash(key)
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.Seq != uint64(n):
+			return fmt.Errorf("%w: entry %d has sequence number %d", ErrBrokenChain, n, e.Seq)
+		case e.Prev != prev:
+			return fmt.Errorf("%w: entry %d does not follow the previous entry", ErrBrokenChain, n)
+		case e.Hash != hash:
+			return fmt.Errorf("%w: entry %d has been modified", ErrBrokenChain, n)
+		}
+		prev = e.Hash
+		last = e
+		return nil
+	})
+	return last, err
+}
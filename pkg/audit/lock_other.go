@@ -0,0 +1,12 @@
+//go:build !unix
+
+package audit
+
+import "os"
+
+// lockFile does nothing on platforms without flock. Only one process may append to the log.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) {}
@@ -2,12 +2,19 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/ustclug/podzol/pkg/docker"
@@ -17,6 +24,8 @@ import (
 type BadStatusCodeError struct {
 	StatusCode int
 	Message    string
+	// Machine-readable error code, if the server sent one
+	Code string
 }
 
 func (e BadStatusCodeError) Error() string {
@@ -25,20 +34,45 @@ func (e BadStatusCodeError) Error() string {
 
 // Client is a client for the podzol server.
 type Client struct {
+	// Host of request URLs, which is the listen address unless it is a Unix socket
 	serverAddr string
+	network    string
+	address    string
+	apiKey     string
 	httpClient *http.Client
 	verbose    bool
 }
 
 // NewClient creates a new client from config.
+// A listen address of the form "unix:/path/to/socket" is dialed as a Unix socket.
 func NewClient(v *viper.Viper) *Client {
-	return &Client{
+	c := &Client{
 		serverAddr: v.GetString("listen-addr"),
+		network:    "tcp",
+		apiKey:     v.GetString("api-key"),
 		httpClient: &http.Client{
 			Timeout: v.GetDuration("timeout"),
 		},
 		verbose: v.GetBool("verbose"),
 	}
+	c.address = c.serverAddr
+	if path, ok := strings.CutPrefix(c.serverAddr, "unix:"); ok {
+		c.serverAddr = "localhost"
+		c.network = "unix"
+		c.address = path
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return c.dial(ctx)
+		}
+		c.httpClient.Transport = transport
+	}
+	return c
+}
+
+// dial connects to the server.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, c.network, c.address)
 }
 
 // MakeURL creates a URL from the given path.
@@ -67,7 +101,63 @@ func (c *Client) makeRequest(method, path string, payload any) (*http.Request, e
 		}
 		fmt.Fprintln(os.Stderr)
 	}
-	return http.NewRequest(method, url, body)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req.Header)
+	return req, nil
+}
+
+// authorize adds the configured API key to the request headers.
+func (c *Client) authorize(h http.Header) {
+	if c.apiKey != "" {
+		h.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+// checkResponse returns an error if the response does not indicate success.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	// Attempt to decode error message
+	var errResp server.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
+		return BadStatusCodeError{StatusCode: resp.StatusCode, Message: errResp.Error, Code: errResp.Code}
+	}
+	// Decode failed, message unavailable
+	return BadStatusCodeError{StatusCode: resp.StatusCode}
+}
+
+// stream performs a GET request and returns the response body for streaming.
+// The configured timeout does not apply, so that the stream may last indefinitely.
+// Cancelling ctx closes the stream.
+func (c *Client) stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	req, err := c.makeRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.streamRequest(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// streamRequest performs a request whose response is streamed, without a timeout.
+func (c *Client) streamRequest(req *http.Request) (*http.Response, error) {
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
 }
 
 // doRequest performs a request and decodes the response into output.
@@ -83,14 +173,8 @@ func (c *Client) doRequest(method, path string, input, output any) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		// Attempt to decode error message
-		var errResp server.ErrorResponse
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
-			return BadStatusCodeError{StatusCode: resp.StatusCode, Message: errResp.Error}
-		}
-		// Decode failed, message unavailable
-		return BadStatusCodeError{StatusCode: resp.StatusCode}
+	if err := checkResponse(resp); err != nil {
+		return err
 	}
 
 	// do not attempt to decode if output is not required
@@ -136,17 +220,121 @@ func (c *Client) Create(opts docker.ContainerOptions) (data docker.ContainerInfo
 	return
 }
 
+// CreateAsync queues a container creation, returning the job to poll with Job.
+func (c *Client) CreateAsync(opts docker.ContainerOptions) (data server.Job, err error) {
+	err = c.doRequest(http.MethodPost, "/create?async=true", opts, &data)
+	return
+}
+
+func (c *Client) Job(id string) (data server.Job, err error) {
+	err = c.doRequest(http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &data)
+	return
+}
+
 func (c *Client) Remove(opts docker.ContainerOptions) (err error) {
 	err = c.doRequest(http.MethodPost, "/remove", opts, nil)
 	return
 }
 
+// RemoveAll removes the containers matching the filter, or only selects them if dryRun is set.
+func (c *Client) RemoveAll(filter docker.ContainerFilter, dryRun bool) (data server.RemoveResponse, err error) {
+	req := server.RemoveRequest{ContainerFilter: filter, DryRun: dryRun}
+	err = c.doRequest(http.MethodPost, "/remove", req, &data)
+	return
+}
+
+func (c *Client) Restart(opts docker.ContainerOptions) (data docker.ContainerInfo, err error) {
+	err = c.doRequest(http.MethodPost, "/restart", opts, &data)
+	return
+}
+
+func (c *Client) Reset(opts docker.ContainerOptions) (data docker.ContainerInfo, err error) {
+	err = c.doRequest(http.MethodPost, "/reset", opts, &data)
+	return
+}
+
+// Logs streams the logs of a container. The caller must close the returned reader.
+// Stdout and stderr are multiplexed as by Docker, and can be separated with stdcopy.StdCopy.
+func (c *Client) Logs(opts docker.ContainerOptions, logOpts docker.LogOptions) (io.ReadCloser, error) {
+	q := url.Values{}
+	q.Set("user", strconv.Itoa(opts.User))
+	q.Set("app", opts.AppName)
+	q.Set("follow", strconv.FormatBool(logOpts.Follow))
+	q.Set("stdout", strconv.FormatBool(logOpts.Stdout))
+	q.Set("stderr", strconv.FormatBool(logOpts.Stderr))
+	if logOpts.Tail != "" {
+		q.Set("tail", logOpts.Tail)
+	}
+	if logOpts.Since != "" {
+		q.Set("since", logOpts.Since)
+	}
+	req, err := c.makeRequest(http.MethodGet, "/logs?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", server.MediaMultiplexed)
+	resp, err := c.streamRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 func (c *Client) List(opts docker.ContainerOptions) (data []docker.ContainerInfo, err error) {
 	err = c.doRequest(http.MethodPost, "/list", opts, &data)
 	return
 }
 
-func (c *Client) Purge() (data []docker.ContainerInfo, err error) {
-	err = c.doRequest(http.MethodPost, "/purge", nil, &data)
+func (c *Client) Inspect(opts docker.ContainerOptions) (data docker.ContainerInfo, err error) {
+	err = c.doRequest(http.MethodPost, "/inspect", opts, &data)
+	return
+}
+
+// Extend sets the deadline of the named container, using API v2.
+func (c *Client) Extend(name string, deadline time.Time) (data docker.ContainerInfo, err error) {
+	patch := server.ContainerPatch{Deadline: deadline.Unix()}
+	err = c.doRequest(http.MethodPatch, "/api/v2/containers/"+url.PathEscape(name), patch, &data)
+	return
+}
+
+// Stats returns a snapshot of the resource usage of running containers, filtered by user and app if set.
+func (c *Client) Stats(opts docker.ContainerOptions) (data []docker.ContainerStats, err error) {
+	q := url.Values{}
+	if opts.User != 0 {
+		q.Set("user", strconv.Itoa(opts.User))
+	}
+	if opts.AppName != "" {
+		q.Set("app", opts.AppName)
+	}
+	err = c.doRequest(http.MethodGet, "/stats?"+q.Encode(), nil, &data)
+	return
+}
+
+// Purge removes expired containers, returning the containers attempted.
+// Containers that could not be removed are reported as a joined error of docker.ContainerActionError.
+func (c *Client) Purge() ([]docker.ContainerInfo, error) {
+	var data server.PurgeResponse
+	if err := c.doRequest(http.MethodPost, "/purge", nil, &data); err != nil {
+		return nil, err
+	}
+	errs := make([]error, 0, len(data.Errors))
+	for _, e := range data.Errors {
+		errs = append(errs, e)
+	}
+	return data.Containers, errors.Join(errs...)
+}
+
+func (c *Client) Images() (data []docker.ImageInfo, err error) {
+	err = c.doRequest(http.MethodGet, "/images", nil, &data)
+	return
+}
+
+func (c *Client) PullImages() (data []docker.ImageInfo, err error) {
+	err = c.doRequest(http.MethodPost, "/images/pull", nil, &data)
+	return
+}
+
+func (c *Client) PruneImages() (data []docker.ImageInfo, err error) {
+	err = c.doRequest(http.MethodPost, "/images/prune", nil, &data)
 	return
 }
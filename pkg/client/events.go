@@ -0,0 +1,65 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// Watch subscribes to lifecycle events of containers, filtered by user and app if set.
+// The returned channel is closed when ctx is done or the connection is lost.
+// Events are not replayed after a reconnect, so callers should List again to catch up.
+func (c *Client) Watch(ctx context.Context, opts docker.ContainerOptions) (<-chan docker.Event, error) {
+	q := url.Values{}
+	if opts.User != 0 {
+		q.Set("user", strconv.Itoa(opts.User))
+	}
+	if opts.AppName != "" {
+		q.Set("app", opts.AppName)
+	}
+	body, err := c.stream(ctx, "/events?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan docker.Event)
+	go func() {
+		defer close(events)
+		defer body.Close()
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		var data strings.Builder
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				// End of an event
+				if data.Len() == 0 {
+					continue
+				}
+				var e docker.Event
+				err := json.Unmarshal([]byte(data.String()), &e)
+				data.Reset()
+				if err != nil {
+					continue
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			case strings.HasPrefix(line, "data:"):
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/server"
+	"golang.org/x/net/websocket"
+)
+
+// ExecConn is a command running in a container, relayed by the server.
+type ExecConn struct {
+	ws *websocket.Conn
+}
+
+// Exec starts a command in a container. The caller must close the returned connection.
+func (c *Client) Exec(opts docker.ContainerOptions, execOpts docker.ExecOptions) (*ExecConn, error) {
+	q := url.Values{}
+	q.Set("user", strconv.Itoa(opts.User))
+	q.Set("app", opts.AppName)
+	q["cmd"] = execOpts.Cmd
+	q.Set("tty", strconv.FormatBool(execOpts.Tty))
+	if execOpts.Rows > 0 && execOpts.Cols > 0 {
+		q.Set("rows", strconv.FormatUint(uint64(execOpts.Rows), 10))
+		q.Set("cols", strconv.FormatUint(uint64(execOpts.Cols), 10))
+	}
+	if execOpts.User != "" {
+		q.Set("exec-user", execOpts.User)
+	}
+	q["env"] = execOpts.Env
+
+	config, err := websocket.NewConfig("ws://"+c.serverAddr+"/exec?"+q.Encode(), c.makeURL("/"))
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(config.Header)
+	conn, err := c.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return &ExecConn{ws: ws}, nil
+}
+
+func (e *ExecConn) send(channel byte, payload []byte) error {
+	return websocket.Message.Send(e.ws, append([]byte{channel}, payload...))
+}
+
+// Resize the terminal of the command.
+func (e *ExecConn) Resize(rows, cols uint) error {
+	b, err := json.Marshal(server.TerminalSize{Rows: rows, Cols: cols})
+	if err != nil {
+		return err
+	}
+	return e.send(server.ChannelResize, b)
+}
+
+// Stream copies stdin to the command and its output to stdout and stderr, and returns its exit code.
+// Standard input of the command is closed when stdin reaches EOF, or immediately if stdin is nil.
+func (e *ExecConn) Stream(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	go func() {
+		if stdin != nil {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				if n > 0 {
+					if err := e.send(server.ChannelStdin, buf[:n]); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		_ = e.send(server.ChannelStdin, nil)
+	}()
+
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(e.ws, &msg); err != nil {
+			if err == io.EOF {
+				return 0, errors.New("connection closed before the command exited")
+			}
+			return 0, err
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case server.ChannelStdout:
+			if _, err := stdout.Write(msg[1:]); err != nil {
+				return 0, err
+			}
+		case server.ChannelStderr:
+			if _, err := stderr.Write(msg[1:]); err != nil {
+				return 0, err
+			}
+		case server.ChannelExit:
+			var exit server.ExecExit
+			if err := json.Unmarshal(msg[1:], &exit); err != nil {
+				return 0, err
+			}
+			if exit.Error != "" {
+				return exit.Code, errors.New(exit.Error)
+			}
+			return exit.Code, nil
+		}
+	}
+}
+
+// Close the connection, which also detaches from the command.
+func (e *ExecConn) Close() error {
+	return e.ws.Close()
+}
@@ -30,6 +30,34 @@ func init() {
 	viper.SetEnvPrefix(strings.ToUpper(pkg.Name))
 
 	viper.SetDefault("listen-addr", "127.0.0.1:9998")
+	viper.SetDefault("listen-mode", "0660")
+	viper.SetDefault("listen-group", "")
 	viper.SetDefault("http-addr", "127.0.0.1:9999")
 	viper.SetDefault("container-prefix", strings.ToLower(pkg.Name))
+	viper.SetDefault("egress-helper-image", "")
+	viper.SetDefault("images.pull-on-start", false)
+	viper.SetDefault("images.pin", false)
+	viper.SetDefault("images.mirror", "")
+	viper.SetDefault("state-file", "")
+	viper.SetDefault("state-retention", "1h")
+	viper.SetDefault("create-policy", "error")
+	viper.SetDefault("idempotency-retention", "24h")
+	viper.SetDefault("reset-cooldown", "1m")
+	viper.SetDefault("create-workers", 4)
+	viper.SetDefault("create-queue", 100)
+	viper.SetDefault("job-retention", "10m")
+	viper.SetDefault("api-key", "")
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "text")
+	viper.SetDefault("log.file", "")
+	viper.SetDefault("metrics-addr", "")
+	viper.SetDefault("grpc-addr", "")
+	viper.SetDefault("shutdown-timeout", "30s")
+	viper.SetDefault("audit-log", "")
+	viper.SetDefault("audit-key", "")
+	viper.SetDefault("ssh.listen-addr", "")
+	viper.SetDefault("ssh.host-key", "/etc/podzol/ssh_host_ed25519_key")
+	viper.SetDefault("ssh.allow-port-forwarding", false)
+	viper.SetDefault("ssh.login-timeout", "30s")
+	viper.SetDefault("ssh.max-startups", 100)
 }
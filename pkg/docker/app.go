@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultPort is the port applications listen on inside the container, unless configured otherwise.
+const DefaultPort = 8080
+
+// AppConfig is the per-application configuration, read from the "apps" key.
+type AppConfig struct {
+	// Default image, used when a create request does not specify one.
+	Image string `mapstructure:"image"`
+	// Port the application listens on inside the container. Defaults to DefaultPort.
+	Port int `mapstructure:"port"`
+
+	Readiness ReadinessCheck `mapstructure:"readiness"`
+
+	Egress          EgressPolicy `mapstructure:"egress"`
+	SecurityProfile string       `mapstructure:"security-profile"`
+
+	// What to do if the container already exists. Defaults to the global "create-policy".
+	CreatePolicy string `mapstructure:"create-policy"`
+
+	// Serve a web terminal on the hostname instead of proxying to Port.
+	Terminal TerminalConfig `mapstructure:"terminal"`
+	// Accept sessions through the SSH gateway.
+	SSH SSHConfig `mapstructure:"ssh"`
+}
+
+// loadApps reads per-application configuration from v and validates it against the loaded security profiles.
+func loadApps(v *viper.Viper, profiles map[string]*SecurityProfile) (map[string]AppConfig, error) {
+	apps := make(map[string]AppConfig)
+	if err := v.UnmarshalKey("apps", &apps); err != nil {
+		return nil, err
+	}
+	for name, app := range apps {
+		if err := app.Egress.validate(); err != nil {
+			return nil, fmt.Errorf("app %q: %w", name, err)
+		}
+		if err := app.Readiness.validate(); err != nil {
+			return nil, fmt.Errorf("app %q: %w", name, err)
+		}
+		if app.CreatePolicy != "" {
+			if err := validateCreatePolicy(app.CreatePolicy); err != nil {
+				return nil, fmt.Errorf("app %q: %w", name, err)
+			}
+		}
+		if err := app.SSH.validate(); err != nil {
+			return nil, fmt.Errorf("app %q: %w", name, err)
+		}
+		if app.Port < 0 || app.Port > 65535 {
+			return nil, fmt.Errorf("app %q: invalid port %d", name, app.Port)
+		}
+		if app.SecurityProfile != "" && profiles[app.SecurityProfile] == nil {
+			return nil, fmt.Errorf("app %q: unknown security profile %q", name, app.SecurityProfile)
+		}
+	}
+	return apps, nil
+}
+
+// port returns the configured port, or DefaultPort.
+func (a AppConfig) port() int {
+	if a.Port == 0 {
+		return DefaultPort
+	}
+	return a.Port
+}
+
+// App returns the configuration for the named application.
+// Applications not present in the config get the zero value.
+// Names are case-insensitive, as the keys of the config are lowercased when loaded.
+func (c *Client) App(name string) AppConfig {
+	return c.apps[strings.ToLower(name)]
+}
@@ -2,6 +2,8 @@ package docker
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"sync"
 
 	"github.com/docker/docker/api/types"
@@ -13,8 +15,22 @@ type Client struct {
 	c      *client.Client
 	prefix string
 
-	hostnameMap     map[string]string
-	hostnameMapLock sync.RWMutex
+	apps         map[string]AppConfig
+	profiles     map[string]*SecurityProfile
+	egressHelper string
+
+	defaultCreatePolicy string
+
+	imageMirror string
+	pullOnStart bool
+	pinImages   bool
+	pinned      map[string]string
+	pinnedLock  sync.RWMutex
+
+	index  *index
+	events eventBus
+	// Stops watching Docker events, see Release
+	stopWatch context.CancelFunc
 }
 
 func NewClient(v *viper.Viper) (*Client, error) {
@@ -22,39 +38,68 @@ func NewClient(v *viper.Viper) (*Client, error) {
 		client.FromEnv,
 		client.WithAPIVersionNegotiation(),
 	)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := loadSecurityProfiles(v)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := loadApps(v, profiles)
+	if err != nil {
+		return nil, err
+	}
+	createPolicy := v.GetString("create-policy")
+	if err := validateCreatePolicy(createPolicy); err != nil {
+		return nil, err
+	}
+	idx, err := newIndex(v.GetString("state-file"), v.GetDuration("state-retention"))
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
 	return &Client{
-		c:           cli,
-		prefix:      v.GetString("container-prefix"),
-		hostnameMap: make(map[string]string),
-	}, err
-}
+		c:            cli,
+		prefix:       v.GetString("container-prefix"),
+		apps:         apps,
+		profiles:     profiles,
+		egressHelper: v.GetString("egress-helper-image"),
 
-func (c *Client) Info(ctx context.Context) (types.Info, error) {
-	return c.c.Info(ctx)
-}
+		defaultCreatePolicy: createPolicy,
 
-func (c *Client) LookupHostname(ctx context.Context) (string, bool) {
-	c.hostnameMapLock.RLock()
-	defer c.hostnameMapLock.RUnlock()
-	ip, ok := c.hostnameMap[c.prefix]
-	return ip, ok
+		imageMirror: v.GetString("images.mirror"),
+		pullOnStart: v.GetBool("images.pull-on-start"),
+		pinImages:   v.GetBool("images.pin"),
+		pinned:      make(map[string]string),
+		index:       idx,
+	}, nil
 }
 
-func (c *Client) AddHostname(ctx context.Context, id string) error {
-	ip, err := c.GetIP(ctx, id)
-	if err != nil {
+// Init prepares the Docker daemon for use, e.g. creating required networks,
+// and starts watching Docker events until ctx is done.
+func (c *Client) Init(ctx context.Context) error {
+	if err := c.ensureNetworks(ctx); err != nil {
 		return err
 	}
-
-	c.hostnameMapLock.Lock()
-	defer c.hostnameMapLock.Unlock()
-	c.hostnameMap[c.prefix] = ip
+	if err := c.sync(ctx); err != nil {
+		return err
+	}
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	c.stopWatch = stopWatch
+	go c.Watch(watchCtx)
+	if c.pullOnStart {
+		for _, info := range c.PullImages(ctx) {
+			if info.Error != "" {
+				// Not fatal, the image will be pulled again on demand
+				slog.Warn("failed to pull image", "image", info.Image, "error", info.Error)
+			}
+		}
+	} else {
+		// Pin the images pulled before the server started
+		c.pin(c.images(ctx, false))
+	}
 	return nil
 }
 
-func (c *Client) RemoveHostname(ctx context.Context, hostname string) error {
-	c.hostnameMapLock.Lock()
-	defer c.hostnameMapLock.Unlock()
-	delete(c.hostnameMap, hostname)
-	return nil
+func (c *Client) Info(ctx context.Context) (types.Info, error) {
+	return c.c.Info(ctx)
 }
@@ -5,14 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
 	"github.com/ustclug/podzol/pkg"
+	"github.com/ustclug/podzol/pkg/logging"
+	"github.com/ustclug/podzol/pkg/metrics"
 )
 
 // ContainerOptions is the options for Create, Remove and List.
@@ -23,6 +25,11 @@ type ContainerOptions struct {
 	Hostname string        `json:"hostname"`
 	Image    string        `json:"image"`
 	Lifetime time.Duration `json:"lifetime"`
+
+	// Token digest carried over from an existing container, if Token is empty
+	tokenHash string
+	// Deadline carried over from an existing container, overriding Lifetime
+	deadline time.Time
 }
 
 // Auxiliary struct for JSON.
@@ -49,6 +56,8 @@ func (c *ContainerOptions) UnmarshalJSON(b []byte) (err error) {
 		return
 	}
 	switch lifetime := aux.Lifetime.(type) {
+	case nil:
+		// Not required for Remove and List
 	case string:
 		c.Lifetime, err = time.ParseDuration(lifetime)
 	case float64:
@@ -64,6 +73,18 @@ type ContainerLabel struct {
 	User     int           `json:"user"`
 	App      string        `json:"challenge"`
 	Lifetime time.Duration `json:"lifetime"`
+	Hostname string        `json:"hostname,omitempty"`
+	Port     int           `json:"port,omitempty"`
+
+	Egress  *EgressPolicy `json:"egress,omitempty"`
+	Profile string        `json:"profile,omitempty"`
+
+	// SHA-256 digest of the token, for web terminal and SSH access
+	TokenHash string `json:"token_hash,omitempty"`
+	Terminal  bool   `json:"terminal,omitempty"`
+
+	// Absolute deadline of a reset container, overriding its creation time plus Lifetime
+	Deadline time.Time `json:"deadline,omitempty"`
 }
 
 // Auxiliary struct for JSON.
@@ -74,12 +95,16 @@ type containerLabelS struct {
 	*containerLabelA
 
 	Lifetime string `json:"lifetime"`
+	Deadline int64  `json:"deadline,omitempty"`
 }
 
-// MarshalJSON implements json.Marshaler.
+// MarshalJSON implements json.Marshaler. Note that Deadline is exported as a Unix timestamp.
 func (c ContainerLabel) MarshalJSON() ([]byte, error) {
 	aux := &containerLabelS{containerLabelA: (*containerLabelA)(&c)}
 	aux.Lifetime = c.Lifetime.String()
+	if !c.Deadline.IsZero() {
+		aux.Deadline = c.Deadline.Unix()
+	}
 	return json.Marshal(aux)
 }
 
@@ -89,6 +114,9 @@ func (c *ContainerLabel) UnmarshalJSON(b []byte) (err error) {
 	if err = json.Unmarshal(b, aux); err != nil {
 		return
 	}
+	if aux.Deadline != 0 {
+		c.Deadline = time.Unix(aux.Deadline, 0)
+	}
 	c.Lifetime, err = time.ParseDuration(aux.Lifetime)
 	return
 }
@@ -99,6 +127,25 @@ type ContainerInfo struct {
 	ID       string    `json:"id"`
 	Hostname string    `json:"hostname"`
 	Deadline time.Time `json:"deadline"`
+
+	User    int           `json:"user,omitempty"`
+	App     string        `json:"app,omitempty"`
+	Port    int           `json:"port,omitempty"`
+	Network string        `json:"network,omitempty"`
+	Egress  *EgressPolicy `json:"egress,omitempty"`
+	Profile string        `json:"profile,omitempty"`
+
+	// Last known state, and why the container stopped or was removed
+	State  string `json:"state,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// Outcome of the readiness check, if any
+	Readiness string `json:"readiness,omitempty"`
+
+	// Whether the hostname serves a web terminal instead of being proxied
+	Terminal bool `json:"terminal,omitempty"`
+
+	tokenHash string
 }
 
 // Auxiliary struct for JSON.
@@ -133,54 +180,255 @@ func (c *Client) ContainerName(opts ContainerOptions) string {
 	return fmt.Sprintf("%s_%d_%s_1", c.prefix, opts.User, opts.AppName)
 }
 
+// managed reports whether a container belongs to this server, rather than to another one sharing the Docker daemon.
+func (c *Client) managed(info ContainerInfo) bool {
+	return c.ContainerName(ContainerOptions{User: info.User, AppName: info.App}) == info.Name
+}
+
 // Construct JSON data from options.
 func (opts *ContainerOptions) Label() (string, error) {
-	b, err := json.Marshal(ContainerLabel{
+	return opts.label(AppConfig{})
+}
+
+func (opts *ContainerOptions) label(app AppConfig) (string, error) {
+	label := ContainerLabel{
 		User:     opts.User,
 		App:      opts.AppName,
 		Lifetime: opts.Lifetime,
-	})
+		Hostname: opts.Hostname,
+		Port:     app.port(),
+		Profile:  app.SecurityProfile,
+		Deadline: opts.deadline,
+	}
+	if app.Egress.Mode != EgressDefault {
+		label.Egress = &app.Egress
+	}
+	if app.Terminal.Enabled || app.SSH.Mode != SSHDisabled {
+		label.TokenHash = opts.tokenDigest()
+		if label.TokenHash == "" {
+			return "", errors.New("a token is required for web terminal or SSH access")
+		}
+	}
+	label.Terminal = app.Terminal.Enabled
+	b, err := json.Marshal(label)
 	return string(b), err
 }
 
+// ErrInvalidLabel is returned for containers whose podzol label cannot be parsed.
+var ErrInvalidLabel = errors.New("invalid container label")
+
+// parseLabel extracts the podzol label from container labels.
+func parseLabel(labels map[string]string) (ContainerLabel, error) {
+	var label ContainerLabel
+	if err := json.Unmarshal([]byte(labels[pkg.ID]), &label); err != nil {
+		return label, fmt.Errorf("%w: %v", ErrInvalidLabel, err)
+	}
+	return label, nil
+}
+
+// info constructs ContainerInfo from the label and basic container data.
+func (label ContainerLabel) info(name, id string, created time.Time) ContainerInfo {
+	deadline := created.Truncate(time.Second).Add(label.Lifetime)
+	if !label.Deadline.IsZero() {
+		deadline = label.Deadline
+	}
+	return ContainerInfo{
+		Name:     strings.TrimPrefix(name, "/"),
+		ID:       id,
+		Hostname: label.Hostname,
+		Deadline: deadline,
+		User:     label.User,
+		App:      label.App,
+		Port:     label.Port,
+		Egress:   label.Egress,
+		Profile:  label.Profile,
+
+		Terminal:  label.Terminal,
+		tokenHash: label.TokenHash,
+	}
+}
+
+// Policies for creating a container when one already exists for the same user and app.
+const (
+	CreateError          = "error"
+	CreateReturnExisting = "return-existing"
+	CreateReplace        = "replace"
+)
+
+// ErrContainerExists is returned by Create if the container exists and the policy is CreateError.
+var ErrContainerExists = errors.New("container already exists")
+
+func validateCreatePolicy(policy string) error {
+	switch policy {
+	case CreateError, CreateReturnExisting, CreateReplace:
+		return nil
+	default:
+		return fmt.Errorf("invalid create policy: %q", policy)
+	}
+}
+
+// createPolicy returns the create policy of the app, falling back to the global one.
+func (c *Client) createPolicy(app AppConfig) string {
+	if app.CreatePolicy != "" {
+		return app.CreatePolicy
+	}
+	return c.defaultCreatePolicy
+}
+
+// checkExisting applies the create policy to an existing container with the given name.
+// It returns the existing container if it should be returned instead of creating a new one.
+func (c *Client) checkExisting(ctx context.Context, name, policy string) (*ContainerInfo, error) {
+	info, _, err := c.inspect(ctx, name)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch policy {
+	case CreateReturnExisting:
+		if entry, ok := c.index.get(name); ok {
+			entry.annotate(&info)
+		}
+		return &info, nil
+	case CreateReplace:
+		return nil, c.removeWithReason(ctx, name, ReasonReplaced)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrContainerExists, name)
+	}
+}
+
 // Create a container from the given options.
+// If the container already exists, the create policy of the app decides the outcome.
 func (c *Client) Create(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
-	label, err := opts.Label()
+	start := time.Now()
+	info, err := c.create(ctx, opts)
+	observe(opCreate, start, err)
+	return info, err
+}
+
+func (c *Client) create(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
+	app := c.App(opts.AppName)
+	label, err := opts.label(app)
 	if err != nil {
 		return ContainerInfo{}, err
 	}
 
 	containerName := c.ContainerName(opts)
+	policy := c.createPolicy(app)
+	if existing, err := c.checkExisting(ctx, containerName, policy); err != nil {
+		return ContainerInfo{}, err
+	} else if existing != nil {
+		return *existing, nil
+	}
+	image := opts.Image
+	if image == "" {
+		image = app.Image
+	}
 
 	containerConfig := &container.Config{
 		Hostname: containerName,
-		Image:    opts.Image,
+		Image:    c.resolveImage(image),
 		Labels:   map[string]string{pkg.ID: label},
 	}
 
+	network := c.egressNetwork(app.Egress)
 	hostConfig := &container.HostConfig{
-		NetworkMode: "bridge",
+		NetworkMode: container.NetworkMode(network),
 		AutoRemove:  true,
 	}
+	if app.Egress.Mode == EgressAllowlist {
+		// No egress until the allowlist is installed
+		hostConfig.NetworkMode = container.NetworkMode(c.stagingNetwork())
+	}
+	if profile := c.profiles[app.SecurityProfile]; profile != nil {
+		profile.apply(containerConfig, hostConfig)
+	}
 
+	progress(ctx, PhaseCreating)
 	createTime := time.Now().Truncate(time.Second)
 	resp, err := c.c.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, containerName)
+	if errdefs.IsNotFound(err) && c.imageMissing(ctx, containerConfig.Image) {
+		// Image missing, pull and retry
+		progress(ctx, PhasePulling)
+		ref, pullErr := c.pull(ctx, c.pinnedRef(image))
+		if pullErr != nil {
+			return ContainerInfo{}, fmt.Errorf("pull %s: %w", image, pullErr)
+		}
+		progress(ctx, PhaseCreating)
+		containerConfig.Image = ref
+		resp, err = c.c.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, containerName)
+	}
+	if errdefs.IsConflict(err) {
+		// Lost the race against a concurrent create
+		if policy == CreateReturnExisting {
+			if existing, err := c.checkExisting(ctx, containerName, policy); err == nil && existing != nil {
+				return *existing, nil
+			}
+		}
+		return ContainerInfo{}, fmt.Errorf("%w: %s", ErrContainerExists, containerName)
+	}
 	if err != nil {
 		return ContainerInfo{}, err
 	}
+	progress(ctx, PhaseStarting)
 	if err := c.c.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
 		// Remove container if start failed
-		_ = c.Remove(ctx, opts)
+		_ = c.removeWithReason(ctx, containerName, ReasonRemoved)
 		return ContainerInfo{}, err
 	}
-	c.AddHostname(ctx, resp.ID)
+	if app.Egress.Mode == EgressAllowlist {
+		if err := c.applyEgress(ctx, resp.ID, app.Egress); err != nil {
+			_ = c.removeWithReason(ctx, containerName, ReasonRemoved)
+			return ContainerInfo{}, fmt.Errorf("apply egress policy: %w", err)
+		}
+	}
+	if err := c.track(ctx, resp.ID); err != nil {
+		// Not fatal, the index will be updated by the start event
+		logging.FromContext(ctx).Warn("failed to track container", "container", containerName, "error", err)
+	}
 
-	return ContainerInfo{
+	info := ContainerInfo{
 		Name:     containerName,
 		ID:       resp.ID,
 		Hostname: opts.Hostname,
 		Deadline: createTime.Add(opts.Lifetime),
-	}, err
+		User:     opts.User,
+		App:      opts.AppName,
+		Port:     app.port(),
+		Network:  network,
+		Profile:  app.SecurityProfile,
+		State:    StateRunning,
+	}
+	if !opts.deadline.IsZero() {
+		info.Deadline = opts.deadline
+	}
+	if app.Egress.Mode != EgressDefault {
+		info.Egress = &app.Egress
+	}
+	info.Terminal = app.Terminal.Enabled
+	if app.Terminal.Enabled || app.SSH.Mode != SSHDisabled {
+		info.tokenHash = opts.tokenDigest()
+	}
+
+	c.publish(EventCreated, info)
+	if app.Readiness.Type == CheckNone {
+		c.publish(EventReady, info)
+	} else {
+		info.Readiness = ReadinessPending
+		c.index.modify(containerName, func(e *indexEntry) {
+			if e.Info.ID == info.ID {
+				e.Info.Readiness = ReadinessPending
+			}
+		})
+		progress(ctx, PhaseChecking)
+		if app.Readiness.Async {
+			go c.ready(context.WithoutCancel(ctx), info, app.Readiness)
+		} else {
+			info.Readiness = c.ready(ctx, info, app.Readiness)
+		}
+	}
+	return info, err
 }
 
 func (c *Client) remove(ctx context.Context, name string) error {
@@ -190,105 +438,450 @@ func (c *Client) remove(ctx context.Context, name string) error {
 	})
 }
 
+// removeWithReason removes a container, recording the reason in the index.
+func (c *Client) removeWithReason(ctx context.Context, name, reason string) error {
+	c.index.expect(name, reason)
+	err := c.remove(ctx, name)
+	if err != nil {
+		c.index.expect(name, "")
+	}
+	return err
+}
+
 // Remove a container.
 func (c *Client) Remove(ctx context.Context, opts ContainerOptions) error {
-	return c.remove(ctx, c.ContainerName(opts))
+	start := time.Now()
+	err := c.removeWithReason(ctx, c.ContainerName(opts), ReasonRemoved)
+	observe(opRemove, start, err)
+	return err
 }
 
-// List containers.
-// Options are used to filter containers.
-// Only UserID, AppName and Port are used.
-func (c *Client) List(ctx context.Context, opts ContainerOptions) ([]ContainerInfo, error) {
-	containers, err := c.c.ContainerList(ctx, types.ContainerListOptions{
-		All:     true,
-		Filters: filters.NewArgs(filters.Arg("label", pkg.ID)),
+// Restart a container in place, keeping its deadline.
+func (c *Client) Restart(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
+	start := time.Now()
+	info, err := c.restart(ctx, opts)
+	observe(opRestart, start, err)
+	return info, err
+}
+
+func (c *Client) restart(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
+	name := c.ContainerName(opts)
+	c.index.expect(name, ReasonRestarted)
+	var err error
+	if egress := c.App(opts.AppName).Egress; egress.Mode == EgressAllowlist {
+		err = c.restartAllowlisted(ctx, name, egress)
+	} else {
+		err = c.c.ContainerRestart(ctx, name, container.StopOptions{})
+	}
+	if err != nil {
+		c.index.expect(name, "")
+		return ContainerInfo{}, err
+	}
+	if err := c.track(ctx, name); err != nil {
+		return ContainerInfo{}, err
+	}
+	return c.Inspect(ctx, opts)
+}
+
+// Reset a container by recreating it from its image,
+// keeping its name, hostname, port and deadline.
+func (c *Client) Reset(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
+	start := time.Now()
+	info, err := c.reset(ctx, opts)
+	observe(opReset, start, err)
+	return info, err
+}
+
+func (c *Client) reset(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
+	name := c.ContainerName(opts)
+	inspect, err := c.c.ContainerInspect(ctx, name)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	label, err := parseLabel(inspect.Config.Labels)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	old := label.info(name, inspect.ID, created)
+	if entry, ok := c.index.get(name); ok {
+		entry.annotate(&old)
+	}
+	deadline := old.Deadline
+	lifetime := deadline.Sub(time.Now().Truncate(time.Second))
+	if lifetime <= 0 {
+		return ContainerInfo{}, fmt.Errorf("container %s has expired", name)
+	}
+
+	// Keep the old container aside until the new one has been created,
+	// so that it is kept if creation fails
+	aside := name + "_reset"
+	if err := c.c.ContainerRename(ctx, inspect.ID, aside); err != nil {
+		return ContainerInfo{}, err
+	}
+	info, err := c.create(ctx, ContainerOptions{
+		User:     label.User,
+		AppName:  label.App,
+		Hostname: label.Hostname,
+		Image:    inspect.Config.Image,
+		Lifetime: lifetime,
+
+		tokenHash: label.TokenHash,
+		deadline:  deadline,
 	})
 	if err != nil {
-		return nil, err
+		if err := c.restoreAside(ctx, inspect.ID, name, deadline); err != nil {
+			logging.FromContext(ctx).Error("failed to restore container after failed reset", "container", name, "error", err)
+		}
+		return ContainerInfo{}, err
 	}
 
-	infos := make([]ContainerInfo, 0)
+	if err := c.remove(ctx, inspect.ID); err != nil {
+		logging.FromContext(ctx).Warn("failed to remove reset container", "container", aside, "error", err)
+	}
+	old.State, old.Reason = StateRemoved, ReasonReset
+	c.publish(EventRemoved, old)
+	return info, nil
+}
 
-	for _, container := range containers {
-		labelStr := container.Labels[pkg.ID]
-		var label ContainerLabel
-		if err := json.Unmarshal([]byte(labelStr), &label); err != nil {
-			// Log error
-			fmt.Fprintln(os.Stderr, err)
-			continue
+// restoreAside gives back its name to a container put aside by reset, and records it in the index again.
+func (c *Client) restoreAside(ctx context.Context, id, name string, deadline time.Time) error {
+	if err := c.c.ContainerRename(ctx, id, name); err != nil {
+		return err
+	}
+	if err := c.track(ctx, id); err != nil {
+		return err
+	}
+	// The entry may have been replaced by the failed container, dropping the deadline override
+	c.index.modify(name, func(e *indexEntry) {
+		if e.Info.ID == id {
+			e.Deadline = deadline
+			e.Info.Deadline = deadline
 		}
+	})
+	return nil
+}
 
-		if opts.User != 0 && label.User != opts.User {
+// List containers.
+// Options are used to filter containers.
+// Only UserID and AppName are used.
+// Containers are listed from the index, which is kept in sync with Docker by Watch.
+// Containers of other servers sharing the Docker daemon are left out.
+func (c *Client) List(ctx context.Context, opts ContainerOptions) ([]ContainerInfo, error) {
+	infos := make([]ContainerInfo, 0)
+	for _, info := range c.index.list() {
+		if !c.managed(info) {
 			continue
 		}
-		if opts.AppName != "" && label.App != opts.AppName {
+		if opts.User != 0 && info.User != opts.User {
 			continue
 		}
-
-		infos = append(infos, ContainerInfo{
-			Name:     strings.TrimPrefix(container.Names[0], "/"),
-			ID:       container.ID,
-			Deadline: time.Unix(container.Created, 0).Add(label.Lifetime),
-		})
+		if opts.AppName != "" && info.App != opts.AppName {
+			continue
+		}
+		infos = append(infos, info)
 	}
 	return infos, nil
 }
 
+// inspect a container by name or ID, returning its info and IP address.
+func (c *Client) inspect(ctx context.Context, name string) (ContainerInfo, string, error) {
+	inspect, err := c.c.ContainerInspect(ctx, name)
+	if err != nil {
+		return ContainerInfo{}, "", err
+	}
+	if _, ok := inspect.Config.Labels[pkg.ID]; !ok {
+		return ContainerInfo{}, "", errdefs.NotFound(fmt.Errorf("container %s is not managed by %s", name, pkg.Name))
+	}
+	label, err := parseLabel(inspect.Config.Labels)
+	if err != nil {
+		return ContainerInfo{}, "", err
+	}
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return ContainerInfo{}, "", err
+	}
+	info := label.info(inspect.Name, inspect.ID, created)
+	info.Network = c.containerNetwork(string(inspect.HostConfig.NetworkMode), label.Egress)
+	info.State = inspect.State.Status
+	return info, containerIP(inspect.NetworkSettings), nil
+}
+
+// containerIP returns the IP address of a container from its network settings.
+func containerIP(settings *types.NetworkSettings) string {
+	if settings == nil {
+		return ""
+	}
+	if settings.IPAddress != "" {
+		return settings.IPAddress
+	}
+	// Containers on user-defined networks have no default IP address
+	for _, network := range settings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress
+		}
+	}
+	return ""
+}
+
+// Inspect a single container.
+// Only UserID and AppName of the options are used.
+// Containers that have been removed recently are reported from the index, along with the reason.
+func (c *Client) Inspect(ctx context.Context, opts ContainerOptions) (ContainerInfo, error) {
+	name := c.ContainerName(opts)
+	info, _, err := c.inspect(ctx, name)
+	entry, ok := c.index.get(name)
+	if err != nil {
+		if errdefs.IsNotFound(err) && ok {
+			return entry.Info, nil
+		}
+		return ContainerInfo{}, err
+	}
+	if ok {
+		entry.annotate(&info)
+	}
+	return info, nil
+}
+
+// Resolve returns the options selecting the container with the given name or ID,
+// for use with Inspect, Remove and Extend.
+// Containers that have been removed recently are resolved from the index.
+func (c *Client) Resolve(ctx context.Context, id string) (ContainerOptions, error) {
+	if entry, ok := c.index.get(id); ok {
+		return ContainerOptions{User: entry.Info.User, AppName: entry.Info.App}, nil
+	}
+	info, _, err := c.inspect(ctx, id)
+	if err != nil {
+		return ContainerOptions{}, err
+	}
+	opts := ContainerOptions{User: info.User, AppName: info.App}
+	if c.ContainerName(opts) != info.Name {
+		return ContainerOptions{}, errdefs.NotFound(fmt.Errorf("container %s is not managed by this server", id))
+	}
+	return opts, nil
+}
+
+// ErrInvalidDeadline is returned by Extend if the deadline is not in the future.
+var ErrInvalidDeadline = errors.New("deadline must be in the future")
+
+// ErrNoStateFile is returned by Extend if the index is not saved to a state file.
+var ErrNoStateFile = errors.New("changing deadlines requires a state-file, so that they survive a restart")
+
+// Extend sets the deadline of a running container, which may be earlier or later than the current one.
+// The deadline is kept in the index, so it is refused unless the index is saved to a state file.
+func (c *Client) Extend(ctx context.Context, opts ContainerOptions, deadline time.Time) (ContainerInfo, error) {
+	start := time.Now()
+	info, err := c.extend(ctx, opts, deadline)
+	observe(opExtend, start, err)
+	return info, err
+}
+
+func (c *Client) extend(ctx context.Context, opts ContainerOptions, deadline time.Time) (ContainerInfo, error) {
+	if !c.index.persistent() {
+		return ContainerInfo{}, ErrNoStateFile
+	}
+	deadline = deadline.Truncate(time.Second)
+	if !deadline.After(time.Now()) {
+		return ContainerInfo{}, ErrInvalidDeadline
+	}
+	name := c.ContainerName(opts)
+	info, _, err := c.inspect(ctx, name)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	if _, ok := c.index.get(name); !ok {
+		// Not seen by the watcher yet
+		if err := c.track(ctx, info.ID); err != nil {
+			return ContainerInfo{}, err
+		}
+	}
+	updated := false
+	c.index.modify(name, func(e *indexEntry) {
+		if e.Info.ID != info.ID {
+			return
+		}
+		e.Deadline = deadline
+		e.Info.Deadline = deadline
+		info = e.Info
+		updated = true
+	})
+	if !updated {
+		return ContainerInfo{}, errdefs.NotFound(fmt.Errorf("container %s has been replaced", name))
+	}
+	c.publish(EventExtended, info)
+	return info, nil
+}
+
 // Get container IP address.
 func (c *Client) GetIP(ctx context.Context, name string) (string, error) {
 	inspect, err := c.c.ContainerInspect(ctx, name)
 	if err != nil {
 		return "", err
 	}
-	return inspect.NetworkSettings.IPAddress, nil
+	if ip := containerIP(inspect.NetworkSettings); ip != "" {
+		return ip, nil
+	}
+	return "", fmt.Errorf("container %s has no IP address", name)
 }
 
 type ContainerActionError struct {
 	Action    string        `json:"action"`
 	Container ContainerInfo `json:"container"`
-	Err       error         `json:"error"`
+	Err       error         `json:"error,omitempty"`
 }
 
 func (e ContainerActionError) Error() string {
 	return fmt.Sprintf("%s %s: %v", e.Action, e.Container.Name, e.Err)
 }
 
+// Auxiliary struct for JSON.
+type containerActionErrorS struct {
+	Action    string        `json:"action"`
+	Container ContainerInfo `json:"container"`
+	Err       string        `json:"error,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Note that Err is exported as its message.
+func (e ContainerActionError) MarshalJSON() ([]byte, error) {
+	aux := containerActionErrorS{Action: e.Action, Container: e.Container}
+	if e.Err != nil {
+		aux.Err = e.Err.Error()
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *ContainerActionError) UnmarshalJSON(data []byte) error {
+	var aux containerActionErrorS
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	e.Action, e.Container, e.Err = aux.Action, aux.Container, nil
+	if aux.Err != "" {
+		e.Err = errors.New(aux.Err)
+	}
+	return nil
+}
+
 // Purge expired containers.
 // Returns the list of (attempted) purged containers.
-// Note that if the metadata of a container is corrupted, it will be removed as well.
+// Containers are selected from the index, so those whose metadata is corrupted, which are not indexed, are left alone,
+// like those of other servers sharing the Docker daemon.
 // The returned error is a list of errors that occurred during the purge.
 func (c *Client) Purge(ctx context.Context) ([]ContainerInfo, error) {
+	start := time.Now()
+	infos, err := c.purge(ctx)
+	observe(opPurge, start, err)
+	return infos, err
+}
+
+func (c *Client) purge(ctx context.Context) ([]ContainerInfo, error) {
+	infos := make([]ContainerInfo, 0)
+	now := time.Now()
+	for _, info := range c.index.list() {
+		if c.managed(info) && now.After(info.Deadline) {
+			infos = append(infos, info)
+		}
+	}
+
+	errs := make([]error, 0)
+	for _, container := range infos {
+		err := c.removeWithReason(ctx, container.Name, ReasonExpired)
+		if err != nil {
+			errs = append(errs, ContainerActionError{
+				Action:    "remove",
+				Container: container,
+				Err:       err,
+			})
+		}
+	}
+	metrics.Purged(len(infos) - len(errs))
+	return infos, errors.Join(errs...)
+}
+
+// ContainerFilter selects containers for RemoveAll.
+// Zero fields match all containers, but at least one field must be set.
+type ContainerFilter struct {
+	User int    `json:"user"`
+	App  string `json:"app"`
+	// Docker label selectors, either "key" or "key=value".
+	// Labels of the image are inherited by the container, so they can be used to select applications.
+	Labels []string `json:"labels"`
+}
+
+// ErrEmptyFilter is returned by RemoveAll if the filter would select all containers.
+var ErrEmptyFilter = errors.New("at least one of user, app or labels is required")
+
+// Validate checks that the filter selects something less than all containers, and that label selectors are well-formed.
+func (f ContainerFilter) Validate() error {
+	if f.User == 0 && f.App == "" && len(f.Labels) == 0 {
+		return ErrEmptyFilter
+	}
+	for _, l := range f.Labels {
+		if key, _, _ := strings.Cut(l, "="); key == "" {
+			return fmt.Errorf("invalid label selector %q", l)
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes the containers matching the filter, or only selects them if dryRun is set.
+// Returns the list of (attempted) removed containers, which is nil if the filter is invalid.
+// Containers whose metadata is corrupted cannot be matched, and are never removed.
+// The returned error is a list of errors that occurred during the removal, like Purge.
+func (c *Client) RemoveAll(ctx context.Context, f ContainerFilter, dryRun bool) ([]ContainerInfo, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	args := filters.NewArgs(filters.Arg("label", pkg.ID))
+	for _, l := range f.Labels {
+		args.Add("label", l)
+	}
 	containers, err := c.c.ContainerList(ctx, types.ContainerListOptions{
 		All:     true,
-		Filters: filters.NewArgs(filters.Arg("label", pkg.ID)),
+		Filters: args,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	infos := make([]ContainerInfo, 0)
-
 	for _, container := range containers {
-		labelStr := container.Labels[pkg.ID]
-		var label ContainerLabel
-		if err := json.Unmarshal([]byte(labelStr), &label); err != nil {
-			// TODO: Log error
-			label.Lifetime = 0
+		name := strings.TrimPrefix(container.Names[0], "/")
+		label, err := parseLabel(container.Labels)
+		if err != nil {
+			logging.FromContext(ctx).Warn("invalid container label", "container", name, "error", err)
+			continue
 		}
-
-		info := ContainerInfo{
-			Name:     strings.TrimPrefix(container.Names[0], "/"),
-			ID:       container.ID,
-			Deadline: time.Unix(container.Created, 0).Add(label.Lifetime),
+		if f.User != 0 && label.User != f.User {
+			continue
 		}
-		if time.Now().After(info.Deadline) {
-			infos = append(infos, info)
+		if f.App != "" && label.App != f.App {
+			continue
 		}
+		info := label.info(name, container.ID, time.Unix(container.Created, 0))
+		if !c.managed(info) {
+			continue
+		}
+		info.Network = c.containerNetwork(container.HostConfig.NetworkMode, label.Egress)
+		info.State = container.State
+		if entry, ok := c.index.get(name); ok {
+			entry.annotate(&info)
+		}
+		infos = append(infos, info)
+	}
+	if dryRun {
+		return infos, nil
 	}
 
 	errs := make([]error, 0)
 	for _, container := range infos {
-		err := c.remove(ctx, container.Name)
+		start := time.Now()
+		err := c.removeWithReason(ctx, container.Name, ReasonRemoved)
+		observe(opRemove, start, err)
 		if err != nil {
 			errs = append(errs, ContainerActionError{
 				Action:    "remove",
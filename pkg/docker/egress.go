@@ -0,0 +1,238 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/ustclug/podzol/pkg"
+)
+
+// Egress modes.
+const (
+	// EgressDefault attaches the container to the default bridge network without restrictions.
+	EgressDefault = ""
+	// EgressNone attaches the container to an internal network with inter-container communication disabled.
+	// Only the host (and thus the proxy) can reach it.
+	EgressNone = "none"
+	// EgressInternal attaches the container to an internal network shared with other services.
+	EgressInternal = "internal-only"
+	// EgressAllowlist attaches the container to the default bridge network and drops all outgoing traffic
+	// except for the allowed destinations.
+	EgressAllowlist = "allowlist"
+)
+
+// EgressPolicy controls outgoing network access of a container.
+type EgressPolicy struct {
+	Mode string `mapstructure:"mode" json:"mode"`
+
+	// Network name for EgressInternal. Defaults to "<prefix>_internal".
+	Network string `mapstructure:"network" json:"network,omitempty"`
+
+	// Allowed destinations for EgressAllowlist.
+	Allow []EgressRule `mapstructure:"allow" json:"allow,omitempty"`
+}
+
+// EgressRule is a single allowed destination.
+// Port and Proto are optional. If Port is set without Proto, TCP is assumed.
+type EgressRule struct {
+	CIDR  string `mapstructure:"cidr" json:"cidr"`
+	Port  int    `mapstructure:"port" json:"port,omitempty"`
+	Proto string `mapstructure:"proto" json:"proto,omitempty"`
+}
+
+func (p EgressPolicy) validate() error {
+	switch p.Mode {
+	case EgressDefault, EgressNone, EgressInternal:
+		if len(p.Allow) > 0 {
+			return fmt.Errorf("egress allowlist requires mode %q", EgressAllowlist)
+		}
+	case EgressAllowlist:
+		for _, rule := range p.Allow {
+			if err := rule.validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("invalid egress mode: %q", p.Mode)
+	}
+	return nil
+}
+
+func (r EgressRule) validate() error {
+	ip, _, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return err
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("egress rule %s: only IPv4 is supported", r.CIDR)
+	}
+	if r.Port < 0 || r.Port > 65535 {
+		return fmt.Errorf("egress rule %s: invalid port %d", r.CIDR, r.Port)
+	}
+	switch r.Proto {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("egress rule %s: invalid protocol %q", r.CIDR, r.Proto)
+	}
+	return nil
+}
+
+// script produces the iptables commands that enforce the allowlist.
+func (p EgressPolicy) script() string {
+	lines := []string{
+		"set -e",
+		"iptables -F OUTPUT",
+		"iptables -A OUTPUT -o lo -j ACCEPT",
+		"iptables -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+	}
+	for _, r := range p.Allow {
+		rule := "iptables -A OUTPUT -d " + r.CIDR
+		proto := r.Proto
+		if proto == "" && r.Port != 0 {
+			proto = "tcp"
+		}
+		if proto != "" {
+			rule += " -p " + proto
+		}
+		if r.Port != 0 {
+			rule += fmt.Sprintf(" --dport %d", r.Port)
+		}
+		lines = append(lines, rule+" -j ACCEPT")
+	}
+	lines = append(lines, "iptables -P OUTPUT DROP")
+	return strings.Join(lines, "\n")
+}
+
+// egressNetwork returns the network a container with the given policy should be attached to.
+func (c *Client) egressNetwork(p EgressPolicy) string {
+	switch p.Mode {
+	case EgressNone:
+		return c.prefix + "_none"
+	case EgressInternal:
+		if p.Network != "" {
+			return p.Network
+		}
+		return c.prefix + "_internal"
+	default:
+		return "bridge"
+	}
+}
+
+// stagingNetwork returns the network allowlisted containers are started on, the one of EgressNone,
+// so that they have no egress until the allowlist is installed.
+func (c *Client) stagingNetwork() string {
+	return c.egressNetwork(EgressPolicy{Mode: EgressNone})
+}
+
+// containerNetwork returns the network of a container, given its network mode at creation.
+// Allowlisted containers are created on the staging network, and moved to the network of their policy once started.
+func (c *Client) containerNetwork(mode string, egress *EgressPolicy) string {
+	if egress != nil && egress.Mode == EgressAllowlist {
+		return c.egressNetwork(*egress)
+	}
+	return mode
+}
+
+// ensureNetworks creates the internal networks required by the configured egress policies.
+func (c *Client) ensureNetworks(ctx context.Context) error {
+	for name, app := range c.apps {
+		p := app.Egress
+		switch p.Mode {
+		case EgressNone, EgressInternal:
+		case EgressAllowlist:
+			if c.egressHelper == "" {
+				return fmt.Errorf("app %q: egress mode %q requires egress-helper-image", name, p.Mode)
+			}
+			p = EgressPolicy{Mode: EgressNone}
+		default:
+			continue
+		}
+
+		network := c.egressNetwork(p)
+		_, err := c.c.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+		options := map[string]string{}
+		if p.Mode == EgressNone {
+			options["com.docker.network.bridge.enable_icc"] = "false"
+		}
+		_, err = c.c.NetworkCreate(ctx, network, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         "bridge",
+			Internal:       true,
+			Options:        options,
+			Labels:         map[string]string{pkg.ID: ""},
+		})
+		if err != nil {
+			return fmt.Errorf("create network %s: %w", network, err)
+		}
+	}
+	return nil
+}
+
+// applyEgress installs the allowlist in the network namespace of the given container, which must be attached
+// to the staging network only, then moves it to the bridge network.
+// iptables rules belong to the network namespace, so they apply to the interfaces attached afterwards as well.
+func (c *Client) applyEgress(ctx context.Context, id string, p EgressPolicy) error {
+	if err := c.runEgressHelper(ctx, id, p); err != nil {
+		return err
+	}
+	if err := c.c.NetworkConnect(ctx, c.egressNetwork(p), id, nil); err != nil {
+		return err
+	}
+	return c.c.NetworkDisconnect(ctx, c.stagingNetwork(), id, false)
+}
+
+// restartAllowlisted restarts a container with an egress allowlist.
+// The rules are lost with the network namespace, so the container is moved back to the staging network first.
+func (c *Client) restartAllowlisted(ctx context.Context, name string, p EgressPolicy) error {
+	if err := c.c.NetworkConnect(ctx, c.stagingNetwork(), name, nil); err != nil {
+		return err
+	}
+	if err := c.c.NetworkDisconnect(ctx, c.egressNetwork(p), name, true); err != nil {
+		return err
+	}
+	if err := c.c.ContainerRestart(ctx, name, container.StopOptions{}); err != nil {
+		return err
+	}
+	return c.applyEgress(ctx, name, p)
+}
+
+// runEgressHelper runs the helper image in the network namespace of the given container to install the allowlist.
+func (c *Client) runEgressHelper(ctx context.Context, id string, p EgressPolicy) error {
+	resp, err := c.c.ContainerCreate(ctx, &container.Config{
+		Image:      c.egressHelper,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Cmd:        []string{p.script()},
+	}, &container.HostConfig{
+		NetworkMode: container.NetworkMode("container:" + id),
+		CapAdd:      []string{"NET_ADMIN"},
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer c.remove(ctx, resp.ID)
+
+	statusCh, errCh := c.c.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := c.c.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	select {
+	case err := <-errCh:
+		return err
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("egress helper exited with status %d", status.StatusCode)
+		}
+	}
+	return nil
+}
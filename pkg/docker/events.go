@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Lifecycle events of containers.
+const (
+	// The container has been created and started.
+	EventCreated = "created"
+	// The readiness check passed, or the app has none.
+	EventReady = "ready"
+	// The deadline of the container has been extended.
+	EventExtended = "extended"
+	// The container has been removed for any reason but expiry. Container.Reason tells why.
+	EventRemoved = "removed"
+	// The container has been removed by purge after its deadline.
+	EventPurged = "purged"
+	// The container stopped on its own or was killed outside podzol.
+	EventDied = "died"
+	// A process of the container was killed for running out of memory.
+	EventOOM = "oom"
+)
+
+// Events lists all lifecycle events.
+var Events = []string{EventCreated, EventReady, EventExtended, EventRemoved, EventPurged, EventDied, EventOOM}
+
+// Event is a lifecycle event of a container.
+type Event struct {
+	Type      string        `json:"type"`
+	Time      time.Time     `json:"time"`
+	Container ContainerInfo `json:"container"`
+}
+
+// eventBus fans out events to subscribers.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving lifecycle events, and a function to unsubscribe.
+// Events are dropped if the subscriber falls more than buffer events behind.
+func (c *Client) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b := &c.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[chan Event]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends an event to all subscribers without blocking.
+func (c *Client) publish(typ string, info ContainerInfo) {
+	e := Event{Type: typ, Time: time.Now().UTC(), Container: info}
+	b := &c.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			slog.Warn("dropped event for slow subscriber", "event", typ, "container", info.Name)
+		}
+	}
+}
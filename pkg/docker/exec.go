@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecOptions describes a command to run in a container.
+type ExecOptions struct {
+	Cmd  []string
+	Tty  bool
+	User string
+	Env  []string
+	// Initial terminal size, if Tty is set.
+	Rows, Cols uint
+}
+
+// ExecSession is a command running in a container, attached to its standard streams.
+type ExecSession struct {
+	c    *Client
+	id   string
+	tty  bool
+	resp types.HijackedResponse
+}
+
+// Exec starts a command in a container.
+func (c *Client) Exec(ctx context.Context, opts ContainerOptions, execOpts ExecOptions) (*ExecSession, error) {
+	config := types.ExecConfig{
+		User:         execOpts.User,
+		Tty:          execOpts.Tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          execOpts.Env,
+		Cmd:          execOpts.Cmd,
+	}
+	if execOpts.Tty && execOpts.Rows > 0 && execOpts.Cols > 0 {
+		config.ConsoleSize = &[2]uint{execOpts.Rows, execOpts.Cols}
+	}
+	resp, err := c.c.ContainerExecCreate(ctx, c.ContainerName(opts), config)
+	if err != nil {
+		return nil, err
+	}
+	hijacked, err := c.c.ContainerExecAttach(ctx, resp.ID, types.ExecStartCheck{Tty: execOpts.Tty})
+	if err != nil {
+		return nil, err
+	}
+	return &ExecSession{
+		c:    c,
+		id:   resp.ID,
+		tty:  execOpts.Tty,
+		resp: hijacked,
+	}, nil
+}
+
+// Write writes to the standard input of the command.
+func (s *ExecSession) Write(b []byte) (int, error) {
+	return s.resp.Conn.Write(b)
+}
+
+// CloseWrite closes the standard input of the command.
+func (s *ExecSession) CloseWrite() error {
+	return s.resp.CloseWrite()
+}
+
+// Output copies the output of the command until it exits.
+// Without a TTY, stdout and stderr are demultiplexed.
+func (s *ExecSession) Output(stdout, stderr io.Writer) error {
+	if s.tty {
+		_, err := io.Copy(stdout, s.resp.Reader)
+		return err
+	}
+	_, err := stdcopy.StdCopy(stdout, stderr, s.resp.Reader)
+	return err
+}
+
+// Resize the terminal of the command.
+func (s *ExecSession) Resize(ctx context.Context, rows, cols uint) error {
+	return s.c.c.ContainerExecResize(ctx, s.id, types.ResizeOptions{Height: rows, Width: cols})
+}
+
+// ExitCode returns the exit code of the command, once it has exited.
+func (s *ExecSession) ExitCode(ctx context.Context) (int, error) {
+	inspect, err := s.c.c.ContainerExecInspect(ctx, s.id)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
+
+// Close detaches from the command.
+func (s *ExecSession) Close() {
+	s.resp.Close()
+}
@@ -0,0 +1,289 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// ImageInfo describes an image in the catalog, i.e. the images configured for applications.
+type ImageInfo struct {
+	Image  string   `json:"image"`
+	ID     string   `json:"id,omitempty"`
+	Digest string   `json:"digest,omitempty"`
+	Apps   []string `json:"apps"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// catalog maps configured images to the applications using them.
+func (c *Client) catalog() map[string][]string {
+	images := make(map[string][]string)
+	for name, app := range c.apps {
+		if app.Image != "" {
+			images[app.Image] = append(images[app.Image], name)
+		}
+	}
+	return images
+}
+
+// resolveImage returns the local reference to create a container from.
+// If pinning is enabled and the image has been pulled, it refers to the digest resolved at the last pull.
+func (c *Client) resolveImage(image string) string {
+	return c.localRef(c.pinnedRef(image))
+}
+
+// pinnedRef returns the digest reference the image is pinned to, or the image itself if it is not pinned.
+func (c *Client) pinnedRef(image string) string {
+	if !c.pinImages {
+		return image
+	}
+	c.pinnedLock.RLock()
+	digest, ok := c.pinned[image]
+	c.pinnedLock.RUnlock()
+	if !ok {
+		return image
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return named.Name() + "@" + digest
+}
+
+// pin records the digests of the pulled catalog images.
+// Images without a repository digest, e.g. built locally, and images referenced by digest are not pinned.
+func (c *Client) pin(infos []ImageInfo) {
+	if !c.pinImages {
+		return
+	}
+	c.pinnedLock.Lock()
+	defer c.pinnedLock.Unlock()
+	for _, info := range infos {
+		if info.Error != "" || strings.Contains(info.Image, "@") {
+			continue
+		}
+		if _, digest, ok := strings.Cut(info.Digest, "@"); ok {
+			c.pinned[info.Image] = digest
+		}
+	}
+}
+
+// pullRef returns the reference to pull the image from, going through the mirror if configured.
+func (c *Client) pullRef(image string) (string, error) {
+	if c.imageMirror == "" {
+		return image, nil
+	}
+	return c.mirrorRef(image)
+}
+
+// localRef returns the reference of a pulled image in the local image store.
+// Images pulled by tag through the mirror are tagged back to their name, but a digest cannot be a tag,
+// so images pulled by digest keep the reference of the mirror.
+func (c *Client) localRef(image string) string {
+	if !strings.Contains(image, "@") {
+		return image
+	}
+	ref, err := c.pullRef(image)
+	if err != nil {
+		return image
+	}
+	return ref
+}
+
+// imageMissing reports whether the image is not present locally,
+// to tell it apart from other missing objects, e.g. networks, when creating a container.
+func (c *Client) imageMissing(ctx context.Context, image string) bool {
+	_, _, err := c.c.ImageInspectWithRaw(ctx, image)
+	return errdefs.IsNotFound(err)
+}
+
+// mirrorRef rewrites the image reference to be pulled from the configured mirror.
+func (c *Client) mirrorRef(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	named = reference.TagNameOnly(named)
+	ref := c.imageMirror + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref += "@" + digested.Digest().String()
+	}
+	return ref, nil
+}
+
+// pull pulls a single image, going through the mirror if configured.
+// Returns the local reference of the pulled image, see localRef.
+func (c *Client) pull(ctx context.Context, image string) (string, error) {
+	ref, err := c.pullRef(image)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := c.c.ImagePull(ctx, ref, types.ImagePullOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		if msg.Error != nil {
+			return "", msg.Error
+		}
+	}
+
+	if ref != image && !strings.Contains(image, "@") {
+		if err := c.c.ImageTag(ctx, ref, image); err != nil {
+			return "", err
+		}
+		// Drop the mirror tag, keeping the image
+		_, _ = c.c.ImageRemove(ctx, ref, types.ImageRemoveOptions{})
+	}
+	return c.localRef(image), nil
+}
+
+// inspectImage fills in the ID and digest of a local image.
+// The digest is the one of the repository the image is pulled from, if known.
+func (c *Client) inspectImage(ctx context.Context, info *ImageInfo) error {
+	inspect, _, err := c.c.ImageInspectWithRaw(ctx, c.localRef(info.Image))
+	if err != nil {
+		return err
+	}
+	info.ID = inspect.ID
+	repo := ""
+	if ref, err := c.pullRef(info.Image); err == nil {
+		if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+			repo = named.Name()
+		}
+	}
+	for _, digest := range inspect.RepoDigests {
+		if named, err := reference.ParseNormalizedNamed(digest); err == nil && named.Name() == repo {
+			info.Digest = digest
+			break
+		}
+	}
+	if info.Digest == "" && len(inspect.RepoDigests) > 0 {
+		info.Digest = inspect.RepoDigests[0]
+	}
+	return nil
+}
+
+func (c *Client) images(ctx context.Context, pull bool) []ImageInfo {
+	catalog := c.catalog()
+	infos := make([]ImageInfo, 0, len(catalog))
+	for image, apps := range catalog {
+		sort.Strings(apps)
+		info := ImageInfo{Image: image, Apps: apps}
+		var err error
+		if pull {
+			_, err = c.pull(ctx, image)
+		}
+		if err == nil {
+			err = c.inspectImage(ctx, &info)
+		}
+		if err != nil {
+			info.Error = err.Error()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Image < infos[j].Image })
+	return infos
+}
+
+// Images lists the catalog images and their local status.
+func (c *Client) Images(ctx context.Context) []ImageInfo {
+	return c.images(ctx, false)
+}
+
+// PullImages pulls all catalog images and reports their digests, which are pinned if enabled.
+// Failures are reported per image in ImageInfo.Error.
+func (c *Client) PullImages(ctx context.Context) []ImageInfo {
+	infos := c.images(ctx, true)
+	c.pin(infos)
+	return infos
+}
+
+// PruneImages removes stale versions of catalog images, i.e. images of the same repositories
+// that are neither the current catalog image nor used by any container.
+// Returns the list of (attempted) removed images.
+func (c *Client) PruneImages(ctx context.Context) ([]ImageInfo, error) {
+	repos := make(map[string]bool)
+	keep := make(map[string]bool)
+	for _, info := range c.Images(ctx) {
+		if info.ID != "" {
+			keep[info.ID] = true
+		}
+		named, err := reference.ParseNormalizedNamed(info.Image)
+		if err != nil {
+			continue
+		}
+		repos[named.Name()] = true
+	}
+
+	containers, err := c.c.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range containers {
+		keep[container.ImageID] = true
+	}
+
+	images, err := c.c.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ImageInfo, 0)
+	errs := make([]error, 0)
+	for _, image := range images {
+		if keep[image.ID] || !matchRepos(repos, image) {
+			continue
+		}
+		info := ImageInfo{ID: image.ID, Apps: []string{}}
+		if len(image.RepoTags) > 0 {
+			info.Image = image.RepoTags[0]
+		}
+		if len(image.RepoDigests) > 0 {
+			info.Digest = image.RepoDigests[0]
+		}
+		_, err := c.c.ImageRemove(ctx, image.ID, types.ImageRemoveOptions{PruneChildren: true})
+		if err != nil {
+			info.Error = err.Error()
+			errs = append(errs, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, errors.Join(errs...)
+}
+
+// matchRepos reports whether any tag or digest of the image belongs to one of the repositories.
+func matchRepos(repos map[string]bool, image types.ImageSummary) bool {
+	refs := append(append([]string{}, image.RepoTags...), image.RepoDigests...)
+	for _, ref := range refs {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			continue
+		}
+		if repos[named.Name()] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,303 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Container states recorded in the index.
+// Other states reported by Docker (e.g. "created", "paused") are recorded as is.
+const (
+	StateRunning = "running"
+	StateExited  = "exited"
+	StateRemoved = "removed"
+)
+
+// Reasons recorded in the index.
+const (
+	ReasonRemoved   = "removed"
+	ReasonExpired   = "expired"
+	ReasonReplaced  = "replaced"
+	ReasonReset     = "reset"
+	ReasonOOM       = "out of memory"
+	ReasonRestarted = "restarted"
+	ReasonKilled    = "killed outside podzol"
+	ReasonExternal  = "removed outside podzol"
+	ReasonVanished  = "removed while podzol was not watching"
+)
+
+// indexEntry is the record of a podzol-managed container.
+type indexEntry struct {
+	Info      ContainerInfo `json:"info"`
+	IP        string        `json:"ip,omitempty"`
+	RemovedAt time.Time     `json:"removed_at,omitempty"`
+	// Deadline set by Extend, overriding the one derived from the label.
+	Deadline time.Time `json:"deadline,omitempty"`
+
+	// Reason to record when the container goes away, set by podzol before acting on it.
+	pending string
+}
+
+// annotate adds what only the index knows about to info, if it is still the same container.
+func (e *indexEntry) annotate(info *ContainerInfo) bool {
+	if e.Info.ID != info.ID {
+		return false
+	}
+	info.Reason = e.Info.Reason
+	info.Readiness = e.Info.Readiness
+	if !e.Deadline.IsZero() {
+		info.Deadline = e.Deadline
+	}
+	return true
+}
+
+// refresh replaces the entry with up-to-date information from Docker,
+// keeping what only the index knows about if it is still the same container.
+func (e *indexEntry) refresh(info ContainerInfo, ip string) {
+	if !e.annotate(&info) {
+		e.pending = ""
+		e.Deadline = time.Time{}
+	}
+	e.Info = info
+	e.IP = ip
+}
+
+// index is the in-memory index of podzol-managed containers, keyed by container name.
+// It is optionally persisted to a state file, so that reasons survive a restart.
+type index struct {
+	mu        sync.RWMutex
+	entries   map[string]*indexEntry
+	hostnames map[string]string // hostname -> container name
+
+	path      string
+	retention time.Duration
+}
+
+func newIndex(path string, retention time.Duration) (*index, error) {
+	idx := &index{
+		entries:   make(map[string]*indexEntry),
+		hostnames: make(map[string]string),
+		path:      path,
+		retention: retention,
+	}
+	if path == "" {
+		return idx, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &idx.entries); err != nil {
+		return nil, err
+	}
+	for name, e := range idx.entries {
+		if e.Info.State != StateRemoved && e.Info.Hostname != "" {
+			idx.hostnames[e.Info.Hostname] = name
+		}
+	}
+	return idx, nil
+}
+
+// get returns a copy of the entry for the named container.
+func (idx *index) get(name string) (indexEntry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	e, ok := idx.entries[name]
+	if !ok {
+		return indexEntry{}, false
+	}
+	return *e, true
+}
+
+// lookupHostname returns the container serving the hostname, and its address (host:port).
+func (idx *index) lookupHostname(hostname string) (ContainerInfo, string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	name, ok := idx.hostnames[hostname]
+	if !ok {
+		return ContainerInfo{}, "", false
+	}
+	e := idx.entries[name]
+	if e.IP == "" {
+		return ContainerInfo{}, "", false
+	}
+	return e.Info, upstreamAddr(e.IP, e.Info.Port), true
+}
+
+// lookupInfo returns the container serving the hostname.
+func (idx *index) lookupInfo(hostname string) (ContainerInfo, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	name, ok := idx.hostnames[hostname]
+	if !ok {
+		return ContainerInfo{}, false
+	}
+	return idx.entries[name].Info, true
+}
+
+// upstreamAddr joins the IP address and port of a container.
+func upstreamAddr(ip string, port int) string {
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// list returns all containers that have not been removed, sorted by name.
+func (idx *index) list() []ContainerInfo {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	infos := make([]ContainerInfo, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		if e.Info.State != StateRemoved {
+			infos = append(infos, e.Info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// update applies f to the entry of the named container, creating it if necessary, and saves the index.
+func (idx *index) update(name string, f func(e *indexEntry)) {
+	idx.apply(name, true, f)
+}
+
+// modify is like update, but does nothing if the container is not in the index.
+func (idx *index) modify(name string, f func(e *indexEntry)) {
+	idx.apply(name, false, f)
+}
+
+func (idx *index) apply(name string, create bool, f func(e *indexEntry)) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	e, ok := idx.entries[name]
+	if !ok {
+		if !create {
+			return
+		}
+		e = &indexEntry{}
+		idx.entries[name] = e
+	}
+	f(e)
+
+	if e.Info.State == StateRemoved {
+		if e.RemovedAt.IsZero() {
+			e.RemovedAt = time.Now()
+		}
+		if idx.hostnames[e.Info.Hostname] == name {
+			delete(idx.hostnames, e.Info.Hostname)
+		}
+	} else {
+		e.RemovedAt = time.Time{}
+		if e.Info.Hostname != "" {
+			idx.hostnames[e.Info.Hostname] = name
+		}
+	}
+	idx.prune()
+	if err := idx.save(); err != nil {
+		slog.Error("failed to save state", "path", idx.path, "error", err)
+	}
+}
+
+// expect records the reason for an upcoming removal of the named container.
+func (idx *index) expect(name, reason string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if e, ok := idx.entries[name]; ok {
+		e.pending = reason
+	}
+}
+
+// prune drops entries that have been removed for longer than the retention period.
+// The caller must hold the lock.
+func (idx *index) prune() {
+	for name, e := range idx.entries {
+		if e.Info.State == StateRemoved && time.Since(e.RemovedAt) > idx.retention {
+			delete(idx.entries, name)
+		}
+	}
+}
+
+// persistent reports whether the index is saved to a state file.
+func (idx *index) persistent() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.path != ""
+}
+
+// save writes the index to the state file, if configured.
+// The caller must hold the lock.
+func (idx *index) save() error {
+	if idx.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(idx.entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(idx.path), filepath.Base(idx.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), idx.path)
+}
+
+// Flush writes the index to the state file, if configured.
+func (c *Client) Flush() error {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	return c.index.save()
+}
+
+// Release stops watching Docker events and writing the state file, once another process has taken over.
+// The index is no longer kept in sync with Docker afterwards.
+func (c *Client) Release() {
+	if c.stopWatch != nil {
+		c.stopWatch()
+	}
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	c.index.path = ""
+}
+
+// LookupHostname returns the container serving the hostname, and its address (host:port).
+func (c *Client) LookupHostname(ctx context.Context, hostname string) (ContainerInfo, string, bool) {
+	return c.index.lookupHostname(hostname)
+}
+
+// Upstream returns the container serving the hostname, and its address (host:port).
+// Hostnames not found in the index are looked up as container names.
+func (c *Client) Upstream(ctx context.Context, hostname string) (ContainerInfo, string, error) {
+	if info, addr, ok := c.LookupHostname(ctx, hostname); ok {
+		return info, addr, nil
+	}
+	info, ip, err := c.inspect(ctx, hostname)
+	if err != nil {
+		return ContainerInfo{}, "", err
+	}
+	if ip == "" {
+		return ContainerInfo{}, "", fmt.Errorf("container %s has no IP address", hostname)
+	}
+	return info, upstreamAddr(ip, info.Port), nil
+}
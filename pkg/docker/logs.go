@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// LogOptions selects the logs to stream.
+type LogOptions struct {
+	Stdout bool
+	Stderr bool
+	Follow bool
+	// Number of lines from the end, or "all".
+	Tail string
+	// Timestamp or duration relative to now, e.g. "10m".
+	Since string
+}
+
+// LogStream is the log output of a container, returned by Logs.
+type LogStream struct {
+	r   io.ReadCloser
+	tty bool
+}
+
+// Copy streams the logs, with stdout and stderr demultiplexed from the Docker stream.
+// Containers with a TTY have a single stream, which is copied to stdout.
+func (l *LogStream) Copy(stdout, stderr io.Writer) error {
+	if l.tty {
+		_, err := io.Copy(stdout, l.r)
+		return err
+	}
+	_, err := stdcopy.StdCopy(stdout, stderr, l.r)
+	return err
+}
+
+func (l *LogStream) Close() error {
+	return l.r.Close()
+}
+
+// Logs opens the logs of a container.
+// Following stops when ctx is done.
+func (c *Client) Logs(ctx context.Context, opts ContainerOptions, logOpts LogOptions) (*LogStream, error) {
+	name := c.ContainerName(opts)
+	inspect, err := c.c.ContainerInspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.c.ContainerLogs(ctx, name, types.ContainerLogsOptions{
+		ShowStdout: logOpts.Stdout,
+		ShowStderr: logOpts.Stderr,
+		Follow:     logOpts.Follow,
+		Tail:       logOpts.Tail,
+		Since:      logOpts.Since,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LogStream{r: r, tty: inspect.Config.Tty}, nil
+}
@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/ustclug/podzol/pkg/metrics"
+)
+
+// Operation names in metrics.
+const (
+	opCreate  = "create"
+	opRemove  = "remove"
+	opRestart = "restart"
+	opReset   = "reset"
+	opExtend  = "extend"
+	opPurge   = "purge"
+)
+
+// errorType classifies an error for metrics.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrContainerExists):
+		return "exists"
+	case errdefs.IsNotFound(err):
+		return "not_found"
+	case errdefs.IsConflict(err):
+		return "conflict"
+	case errdefs.IsInvalidParameter(err):
+		return "invalid"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	case client.IsErrConnectionFailed(err), errdefs.IsUnavailable(err):
+		return "docker_unavailable"
+	default:
+		return "other"
+	}
+}
+
+func observe(operation string, start time.Time, err error) {
+	metrics.ObserveOperation(operation, start, err, errorType(err))
+}
+
+// RunningByApp counts running containers by application, for metrics.
+func (c *Client) RunningByApp() map[string]int {
+	counts := make(map[string]int)
+	for _, info := range c.index.list() {
+		if info.State == StateRunning {
+			counts[info.App]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,31 @@
+package docker
+
+import "context"
+
+// Phases of container creation.
+const (
+	PhaseQueued   = "queued"
+	PhasePulling  = "pulling"
+	PhaseCreating = "creating"
+	PhaseStarting = "starting"
+	PhaseChecking = "checking"
+	PhaseReady    = "ready"
+	PhaseFailed   = "failed"
+)
+
+// ProgressFunc is called when container creation enters a new phase.
+type ProgressFunc func(phase string)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes Create report its phases to f.
+func WithProgress(ctx context.Context, f ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+// progress reports the phase to the ProgressFunc in ctx, if any.
+func progress(ctx context.Context, phase string) {
+	if f, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		f(phase)
+	}
+}
@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/logging"
+)
+
+// Readiness check types.
+const (
+	CheckNone        = ""
+	CheckTCP         = "tcp"
+	CheckHTTP        = "http"
+	CheckHealthcheck = "healthcheck"
+)
+
+// Readiness states reported in ContainerInfo.
+const (
+	ReadinessPending = "pending"
+	ReadinessReady   = "ready"
+	ReadinessFailed  = "failed"
+)
+
+const (
+	defaultReadinessTimeout = 30 * time.Second
+	readinessInterval       = 250 * time.Millisecond
+)
+
+var errNotReady = errors.New("not ready")
+
+// ErrReadinessFailed is returned by CheckReadiness if a container was created, but failed its synchronous readiness check.
+var ErrReadinessFailed = errors.New("readiness check failed")
+
+// CheckReadiness returns ErrReadinessFailed if the readiness check of a container created by Create failed.
+// The container is not removed.
+func CheckReadiness(info ContainerInfo) error {
+	if info.Readiness == ReadinessFailed {
+		return fmt.Errorf("%w: %s", ErrReadinessFailed, info.Name)
+	}
+	return nil
+}
+
+// ReadinessCheck describes how to tell whether a container is ready to serve.
+type ReadinessCheck struct {
+	// One of "tcp", "http" or "healthcheck". Empty to disable the check.
+	Type string `mapstructure:"type"`
+	// Request path for "http". Defaults to "/".
+	Path string `mapstructure:"path"`
+	// How long to wait for the container. Defaults to 30s.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Return from Create immediately, and report readiness in the index.
+	Async bool `mapstructure:"async"`
+}
+
+func (r ReadinessCheck) validate() error {
+	switch r.Type {
+	case CheckNone, CheckTCP, CheckHTTP, CheckHealthcheck:
+	default:
+		return fmt.Errorf("invalid readiness check type: %q", r.Type)
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("invalid readiness timeout: %s", r.Timeout)
+	}
+	return nil
+}
+
+// probe performs a single readiness check.
+// A nil error means the container is ready. Errors wrapped with errNotReady may be retried.
+func (c *Client) probe(ctx context.Context, id string, port int, check ReadinessCheck) error {
+	if check.Type == CheckHealthcheck {
+		inspect, err := c.c.ContainerInspect(ctx, id)
+		if err != nil {
+			return err
+		}
+		if inspect.State.Health == nil {
+			return errors.New("image has no HEALTHCHECK")
+		}
+		if status := inspect.State.Health.Status; status != "healthy" {
+			return fmt.Errorf("%w: %s", errNotReady, status)
+		}
+		return nil
+	}
+
+	ip, err := c.GetIP(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotReady, err)
+	}
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	switch check.Type {
+	case CheckTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errNotReady, err)
+		}
+		conn.Close()
+	case CheckHTTP:
+		path := check.Path
+		if path == "" {
+			path = "/"
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errNotReady, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%w: HTTP %s", errNotReady, resp.Status)
+		}
+	}
+	return nil
+}
+
+// waitReady probes the container until it is ready or the check times out.
+func (c *Client) waitReady(ctx context.Context, id string, port int, check ReadinessCheck) error {
+	timeout := check.Timeout
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+	for {
+		err := c.probe(ctx, id, port, check)
+		if err == nil || !errors.Is(err, errNotReady) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// ready waits for the container to become ready and records the outcome in the index.
+func (c *Client) ready(ctx context.Context, info ContainerInfo, check ReadinessCheck) string {
+	state := ReadinessReady
+	if err := c.waitReady(ctx, info.ID, info.Port, check); err != nil {
+		logging.FromContext(ctx).Warn("readiness check failed",
+			"container", info.Name, "user", info.User, "app", info.App, "error", err)
+		state = ReadinessFailed
+	}
+	c.index.modify(info.Name, func(e *indexEntry) {
+		if e.Info.ID == info.ID {
+			e.Info.Readiness = state
+		}
+	})
+	if state == ReadinessReady {
+		info.Readiness = state
+		c.publish(EventReady, info)
+		progress(ctx, PhaseReady)
+	} else {
+		progress(ctx, PhaseFailed)
+	}
+	return state
+}
@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/spf13/viper"
+)
+
+// SecurityProfile is a named set of hardening options, read from the "security-profiles" key.
+type SecurityProfile struct {
+	ReadOnlyRootfs  bool              `mapstructure:"read-only-rootfs"`
+	Tmpfs           map[string]string `mapstructure:"tmpfs"`
+	CapDrop         []string          `mapstructure:"cap-drop"`
+	CapAdd          []string          `mapstructure:"cap-add"`
+	NoNewPrivileges bool              `mapstructure:"no-new-privileges"`
+
+	// Path to a seccomp profile in JSON, or "unconfined".
+	Seccomp string `mapstructure:"seccomp"`
+	// Name of a loaded AppArmor profile, or path to its source file from which the name is read.
+	AppArmor string `mapstructure:"apparmor"`
+
+	// Set to "host" to opt out of daemon-wide user namespace remapping.
+	UsernsMode string `mapstructure:"userns-mode"`
+	// Alternative OCI runtime, e.g. "runsc".
+	Runtime string `mapstructure:"runtime"`
+	// User (and optionally group) to run as, e.g. "1000:1000".
+	User string `mapstructure:"user"`
+
+	// Resolved from Seccomp and AppArmor by load.
+	seccompJSON  string
+	apparmorName string
+}
+
+var apparmorProfileRe = regexp.MustCompile(`(?m)^\s*profile\s+([^\s{]+)`)
+
+// load validates the profile and resolves the referenced files.
+func (p *SecurityProfile) load() error {
+	switch p.Seccomp {
+	case "":
+	case "unconfined":
+		p.seccompJSON = p.Seccomp
+	default:
+		b, err := os.ReadFile(p.Seccomp)
+		if err != nil {
+			return fmt.Errorf("seccomp profile: %w", err)
+		}
+		buf := new(bytes.Buffer)
+		if err := json.Compact(buf, b); err != nil {
+			return fmt.Errorf("seccomp profile %s: %w", p.Seccomp, err)
+		}
+		p.seccompJSON = buf.String()
+	}
+
+	if strings.ContainsRune(p.AppArmor, '/') {
+		b, err := os.ReadFile(p.AppArmor)
+		if err != nil {
+			return fmt.Errorf("apparmor profile: %w", err)
+		}
+		m := apparmorProfileRe.FindSubmatch(b)
+		if m == nil {
+			return fmt.Errorf("apparmor profile %s: no profile name found", p.AppArmor)
+		}
+		p.apparmorName = string(m[1])
+	} else {
+		p.apparmorName = p.AppArmor
+	}
+
+	switch p.UsernsMode {
+	case "", "host":
+	default:
+		return fmt.Errorf("invalid userns-mode: %q", p.UsernsMode)
+	}
+	return nil
+}
+
+// apply sets the profile options on the container configuration.
+func (p *SecurityProfile) apply(config *container.Config, hostConfig *container.HostConfig) {
+	config.User = p.User
+
+	hostConfig.ReadonlyRootfs = p.ReadOnlyRootfs
+	hostConfig.Tmpfs = p.Tmpfs
+	hostConfig.CapDrop = p.CapDrop
+	hostConfig.CapAdd = p.CapAdd
+	hostConfig.UsernsMode = container.UsernsMode(p.UsernsMode)
+	hostConfig.Runtime = p.Runtime
+	if p.NoNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges")
+	}
+	if p.seccompJSON != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+p.seccompJSON)
+	}
+	if p.apparmorName != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "apparmor="+p.apparmorName)
+	}
+}
+
+// loadSecurityProfiles reads security profiles from v.
+// Profiles referencing missing or malformed files are rejected.
+func loadSecurityProfiles(v *viper.Viper) (map[string]*SecurityProfile, error) {
+	profiles := make(map[string]*SecurityProfile)
+	if err := v.UnmarshalKey("security-profiles", &profiles); err != nil {
+		return nil, err
+	}
+	for name, p := range profiles {
+		if p == nil {
+			p = new(SecurityProfile)
+			profiles[name] = p
+		}
+		if err := p.load(); err != nil {
+			return nil, fmt.Errorf("security profile %q: %w", name, err)
+		}
+	}
+	return profiles, nil
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SSH gateway modes.
+const (
+	SSHDisabled = ""
+	// Run a command in the container for each session.
+	SSHExec = "exec"
+	// Forward sessions to an SSH server running in the container.
+	SSHForward = "forward"
+)
+
+// SSHConfig enables access to the containers of an application through the SSH gateway.
+type SSHConfig struct {
+	Mode string `mapstructure:"mode"`
+	// Command to run in exec mode. Defaults to /bin/sh, which also allows clients to run their own commands.
+	Command []string `mapstructure:"command"`
+	// User to run the command as in exec mode, or to log in as in forward mode.
+	User string `mapstructure:"user"`
+	// Port of the SSH server in forward mode. Defaults to 22.
+	Port int `mapstructure:"port"`
+	// Password to log in with in forward mode. Empty to try without authentication.
+	Password string `mapstructure:"password"`
+}
+
+func (s SSHConfig) validate() error {
+	switch s.Mode {
+	case SSHDisabled, SSHExec:
+	case SSHForward:
+		if s.User == "" {
+			return errors.New("ssh: user is required in forward mode")
+		}
+	default:
+		return fmt.Errorf("invalid ssh mode: %q", s.Mode)
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("invalid ssh port %d", s.Port)
+	}
+	return nil
+}
+
+// ForwardPort returns the port of the SSH server in forward mode.
+func (s SSHConfig) ForwardPort() int {
+	if s.Port == 0 {
+		return 22
+	}
+	return s.Port
+}
+
+// CommandFor returns the command to run for a session, given the command requested by the client, if any.
+// A configured command always takes precedence, with the requested command available in SSH_ORIGINAL_COMMAND.
+func (s SSHConfig) CommandFor(requested string) (cmd, env []string) {
+	if len(s.Command) > 0 {
+		if requested != "" {
+			env = []string{"SSH_ORIGINAL_COMMAND=" + requested}
+		}
+		return s.Command, env
+	}
+	if requested != "" {
+		return []string{"/bin/sh", "-c", requested}, nil
+	}
+	return []string{"/bin/sh"}, nil
+}
+
+// SSHTarget returns the running container with SSH access on the hostname, and its SSH configuration.
+func (c *Client) SSHTarget(ctx context.Context, hostname string) (ContainerInfo, SSHConfig, bool) {
+	info, ok := c.index.lookupInfo(hostname)
+	if !ok || info.State != StateRunning {
+		return ContainerInfo{}, SSHConfig{}, false
+	}
+	config := c.App(info.App).SSH
+	if config.Mode == SSHDisabled {
+		return ContainerInfo{}, SSHConfig{}, false
+	}
+	return info, config, true
+}
+
+// ContainerAddr returns the address (host:port) of the given port of a running container.
+func (c *Client) ContainerAddr(info ContainerInfo, port int) (string, error) {
+	e, ok := c.index.get(info.Name)
+	if !ok || e.IP == "" {
+		return "", fmt.Errorf("container %s has no IP address", info.Name)
+	}
+	return upstreamAddr(e.IP, port), nil
+}
@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+// statsConcurrency limits the number of stats requests to Docker in flight.
+const statsConcurrency = 16
+
+// ContainerStats is a snapshot of the resource usage of a container.
+type ContainerStats struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	User int    `json:"user"`
+	App  string `json:"app"`
+
+	CPUPercent    float64 `json:"cpu_percent"`
+	MemoryUsage   uint64  `json:"memory_usage"`
+	MemoryLimit   uint64  `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	NetworkRx     uint64  `json:"network_rx"`
+	NetworkTx     uint64  `json:"network_tx"`
+	PIDs          uint64  `json:"pids"`
+
+	// Why the snapshot is missing, if it is
+	Error string `json:"error,omitempty"`
+}
+
+// Stats returns a snapshot of the resource usage of running containers.
+// Options are used to filter containers, like List.
+// Each snapshot takes about a second, as Docker samples CPU usage twice.
+func (c *Client) Stats(ctx context.Context, opts ContainerOptions) ([]ContainerStats, error) {
+	infos, err := c.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]ContainerStats, 0, len(infos))
+	for _, info := range infos {
+		if info.State != StateRunning {
+			continue
+		}
+		stats = append(stats, ContainerStats{
+			Name: info.Name,
+			ID:   info.ID,
+			User: info.User,
+			App:  info.App,
+		})
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, statsConcurrency)
+	for i := range stats {
+		wg.Add(1)
+		go func(s *ContainerStats) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := c.stats(ctx, s); err != nil {
+				s.Error = err.Error()
+			}
+		}(&stats[i])
+	}
+	wg.Wait()
+	return stats, nil
+}
+
+// stats fills in the resource usage of a container.
+func (c *Client) stats(ctx context.Context, s *ContainerStats) error {
+	resp, err := c.c.ContainerStats(ctx, s.ID, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var v types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return err
+	}
+
+	// Same calculations as the Docker CLI
+	cpuDelta := float64(v.CPUStats.CPUUsage.TotalUsage) - float64(v.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(v.CPUStats.SystemUsage) - float64(v.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(v.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(v.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		s.CPUPercent = cpuDelta / systemDelta * onlineCPUs * 100
+	}
+
+	s.MemoryUsage = v.MemoryStats.Usage
+	// Page cache that can be reclaimed is not counted, on cgroup v1 and v2 respectively
+	if inactive, ok := v.MemoryStats.Stats["total_inactive_file"]; ok && inactive < s.MemoryUsage {
+		s.MemoryUsage -= inactive
+	} else if inactive, ok := v.MemoryStats.Stats["inactive_file"]; ok && inactive < s.MemoryUsage {
+		s.MemoryUsage -= inactive
+	}
+	s.MemoryLimit = v.MemoryStats.Limit
+	if s.MemoryLimit > 0 {
+		s.MemoryPercent = float64(s.MemoryUsage) / float64(s.MemoryLimit) * 100
+	}
+
+	for _, n := range v.Networks {
+		s.NetworkRx += n.RxBytes
+		s.NetworkTx += n.TxBytes
+	}
+	s.PIDs = v.PidsStats.Current
+	return nil
+}
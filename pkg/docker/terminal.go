@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// TerminalConfig enables a web terminal on the hostname of an application.
+type TerminalConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Command to run for each terminal session. Defaults to /bin/sh.
+	Command []string `mapstructure:"command"`
+	// User to run the command as inside the container. Defaults to the user of the image.
+	User string `mapstructure:"user"`
+}
+
+func (t TerminalConfig) command() []string {
+	if len(t.Command) == 0 {
+		return []string{"/bin/sh"}
+	}
+	return t.Command
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenDigest returns the digest of the token to record in the label, or an empty string.
+func (opts ContainerOptions) tokenDigest() string {
+	if opts.Token != "" {
+		return hashToken(opts.Token)
+	}
+	return opts.tokenHash
+}
+
+// CheckToken reports whether the token is the one the container was created with.
+// It always fails for containers without a web terminal.
+func (c ContainerInfo) CheckToken(token string) bool {
+	if c.tokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(c.tokenHash)) == 1
+}
+
+// Terminal returns the container serving a web terminal on the hostname, if any.
+func (c *Client) Terminal(ctx context.Context, hostname string) (ContainerInfo, bool) {
+	info, ok := c.index.lookupInfo(hostname)
+	if !ok || !info.Terminal || info.State != StateRunning {
+		return ContainerInfo{}, false
+	}
+	return info, true
+}
+
+// ExecTerminal starts the terminal command of the application in the container.
+func (c *Client) ExecTerminal(ctx context.Context, info ContainerInfo, rows, cols uint) (*ExecSession, error) {
+	terminal := c.App(info.App).Terminal
+	opts := ContainerOptions{User: info.User, AppName: info.App}
+	return c.Exec(ctx, opts, ExecOptions{
+		Cmd:  terminal.command(),
+		Tty:  true,
+		User: terminal.User,
+		Rows: rows,
+		Cols: cols,
+	})
+}
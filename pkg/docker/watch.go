@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/ustclug/podzol/pkg"
+)
+
+// track inspects a container and records it in the index.
+func (c *Client) track(ctx context.Context, id string) error {
+	info, ip, err := c.inspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	c.index.update(info.Name, func(e *indexEntry) {
+		e.refresh(info, ip)
+	})
+	return nil
+}
+
+// sync rebuilds the index from the containers known to Docker.
+// Containers missing from Docker are marked as removed.
+func (c *Client) sync(ctx context.Context) error {
+	containers, err := c.c.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", pkg.ID)),
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, container := range containers {
+		label, err := parseLabel(container.Labels)
+		if err != nil {
+			slog.Warn("invalid container label", "container", container.Names[0], "error", err)
+			continue
+		}
+		info := label.info(container.Names[0], container.ID, time.Unix(container.Created, 0))
+		info.Network = c.containerNetwork(container.HostConfig.NetworkMode, label.Egress)
+		info.State = container.State
+		ip := ""
+		if container.NetworkSettings != nil {
+			for _, network := range container.NetworkSettings.Networks {
+				if network.IPAddress != "" {
+					ip = network.IPAddress
+					break
+				}
+			}
+		}
+		seen[info.Name] = true
+		c.index.update(info.Name, func(e *indexEntry) {
+			e.refresh(info, ip)
+		})
+	}
+
+	for _, info := range c.index.list() {
+		if !seen[info.Name] {
+			c.index.modify(info.Name, func(e *indexEntry) {
+				e.Info.State = StateRemoved
+				if e.pending != "" {
+					e.Info.Reason = e.pending
+				} else {
+					e.Info.Reason = ReasonVanished
+				}
+				info = e.Info
+			})
+			c.publish(removedEvent(info), info)
+		}
+	}
+	return nil
+}
+
+// Watch subscribes to Docker events of podzol-managed containers and keeps the index in sync.
+// It blocks until ctx is done, reconnecting on errors.
+func (c *Client) Watch(ctx context.Context) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := c.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("docker events", "error", err, "retry", backoff)
+
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *Client) watch(ctx context.Context) error {
+	msgs, errs := c.c.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("label", pkg.ID),
+		),
+	})
+	// Resync after subscribing, so that nothing is missed in between
+	if err := c.sync(ctx); err != nil {
+		return err
+	}
+	for {
+		select {
+		case msg := <-msgs:
+			c.handleEvent(ctx, msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// handleEvent updates the index according to a container event.
+func (c *Client) handleEvent(ctx context.Context, msg events.Message) {
+	name := msg.Actor.Attributes["name"]
+	if name == "" {
+		return
+	}
+
+	slog.Debug("container event", "container", name, "action", msg.Action)
+	switch msg.Action {
+	case "start":
+		if err := c.track(ctx, msg.Actor.ID); err != nil {
+			slog.Warn("failed to track container", "container", name, "error", err)
+			return
+		}
+		c.index.modify(name, func(e *indexEntry) {
+			e.Info.Reason = ""
+			e.pending = ""
+		})
+	case "restart":
+		c.index.modify(name, func(e *indexEntry) {
+			e.Info.Reason = ReasonRestarted
+		})
+	case "kill":
+		c.index.modify(name, func(e *indexEntry) {
+			if e.pending == "" {
+				e.pending = ReasonKilled
+			}
+		})
+	case "oom":
+		var info *ContainerInfo
+		c.index.modify(name, func(e *indexEntry) {
+			e.Info.Reason = ReasonOOM
+			copied := e.Info
+			info = &copied
+		})
+		if info != nil {
+			c.publish(EventOOM, *info)
+		}
+	case "die":
+		var info *ContainerInfo
+		c.index.modify(name, func(e *indexEntry) {
+			// Stopping a container on purpose is not a death
+			died := e.pending == "" || e.pending == ReasonKilled
+			e.Info.State = StateExited
+			e.IP = ""
+			if e.pending != "" {
+				e.Info.Reason = e.pending
+			} else if e.Info.Reason == "" || e.Info.Reason == ReasonRestarted {
+				e.Info.Reason = "exited with code " + msg.Actor.Attributes["exitCode"]
+			}
+			if died {
+				copied := e.Info
+				info = &copied
+			}
+		})
+		if info != nil {
+			c.publish(EventDied, *info)
+		}
+	case "destroy":
+		var info *ContainerInfo
+		c.index.modify(name, func(e *indexEntry) {
+			if e.Info.ID != "" && e.Info.ID != msg.Actor.ID {
+				// A newer container has taken the name
+				return
+			}
+			e.Info.State = StateRemoved
+			e.IP = ""
+			if e.pending != "" {
+				e.Info.Reason = e.pending
+			} else if e.Info.Reason == "" || e.Info.Reason == ReasonRestarted {
+				e.Info.Reason = ReasonExternal
+			}
+			copied := e.Info
+			info = &copied
+		})
+		if info != nil {
+			c.publish(removedEvent(*info), *info)
+		}
+	}
+}
+
+// removedEvent returns the lifecycle event for a removed container.
+func removedEvent(info ContainerInfo) string {
+	if info.Reason == ReasonExpired {
+		return EventPurged
+	}
+	return EventRemoved
+}
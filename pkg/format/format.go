@@ -4,8 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/olekukonko/tablewriter"
+	"github.com/ustclug/podzol/pkg/audit"
 	"github.com/ustclug/podzol/pkg/docker"
 	"github.com/ustclug/podzol/pkg/utils"
 )
@@ -31,24 +36,168 @@ func ShowContainer(w io.Writer, data docker.ContainerInfo) error {
 		{"ID:", data.ID},
 		{"Timeout:", data.Deadline.String()},
 	})
+	if data.State != "" {
+		table.Append([]string{"State:", data.State})
+	}
+	if data.Reason != "" {
+		table.Append([]string{"Reason:", data.Reason})
+	}
+	if data.Readiness != "" {
+		table.Append([]string{"Readiness:", data.Readiness})
+	}
+	if data.Network != "" {
+		table.Append([]string{"Network:", data.Network})
+	}
+	if data.Egress != nil {
+		table.Append([]string{"Egress:", data.Egress.Mode})
+		for _, r := range data.Egress.Allow {
+			table.Append([]string{"", formatEgressRule(r)})
+		}
+	}
+	if data.Profile != "" {
+		table.Append([]string{"Profile:", data.Profile})
+	}
+	if data.Terminal {
+		table.Append([]string{"Terminal:", "enabled"})
+	}
 	table.Render()
 	return nil
 }
 
+func formatEgressRule(r docker.EgressRule) string {
+	s := "allow " + r.CIDR
+	if r.Port != 0 {
+		s += fmt.Sprintf(" port %d", r.Port)
+	}
+	if r.Proto != "" {
+		s += " " + r.Proto
+	}
+	return s
+}
+
 func ListContainers(w io.Writer, data []docker.ContainerInfo) error {
 	table := makeTable(w)
-	table.SetHeader([]string{"Name", "ID", "Deadline"})
+	table.SetHeader([]string{"Name", "ID", "Deadline", "State"})
 	for _, c := range data {
 		table.Append([]string{
 			c.Name,
 			c.ID[:12],
 			c.Deadline.String(),
+			c.State,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func ListImages(w io.Writer, data []docker.ImageInfo) error {
+	table := makeTable(w)
+	table.SetHeader([]string{"Image", "ID", "Digest", "Apps", "Error"})
+	for _, i := range data {
+		id := strings.TrimPrefix(i.ID, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		table.Append([]string{
+			i.Image,
+			id,
+			i.Digest,
+			strings.Join(i.Apps, ","),
+			i.Error,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func ListStats(w io.Writer, data []docker.ContainerStats) error {
+	table := makeTable(w)
+	table.SetHeader([]string{"Name", "User", "App", "CPU %", "Mem Usage / Limit", "Mem %", "Net I/O", "PIDs"})
+	for _, s := range data {
+		if s.Error != "" {
+			table.Append([]string{s.Name, strconv.Itoa(s.User), s.App, s.Error, "", "", "", ""})
+			continue
+		}
+		table.Append([]string{
+			s.Name,
+			strconv.Itoa(s.User),
+			s.App,
+			fmt.Sprintf("%.2f%%", s.CPUPercent),
+			units.BytesSize(float64(s.MemoryUsage)) + " / " + units.BytesSize(float64(s.MemoryLimit)),
+			fmt.Sprintf("%.2f%%", s.MemoryPercent),
+			units.HumanSize(float64(s.NetworkRx)) + " / " + units.HumanSize(float64(s.NetworkTx)),
+			strconv.FormatUint(s.PIDs, 10),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func ListAuditEntries(w io.Writer, data []audit.Entry) error {
+	table := makeTable(w)
+	table.SetHeader([]string{"Seq", "Time", "Identity", "Source", "Action", "User", "App", "Container", "Outcome", "Detail"})
+	for _, e := range data {
+		user := ""
+		if e.User != 0 {
+			user = strconv.Itoa(e.User)
+		}
+		id := e.ContainerID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		outcome := e.Outcome
+		if e.Error != "" {
+			outcome += ": " + e.Error
+		}
+		table.Append([]string{
+			strconv.FormatUint(e.Seq, 10),
+			e.Time.Local().Format(time.DateTime),
+			e.Identity,
+			e.Source,
+			e.Action,
+			user,
+			e.App,
+			id,
+			outcome,
+			e.Detail,
 		})
 	}
 	table.Render()
 	return nil
 }
 
+func PrintEvent(w io.Writer, e docker.Event) error {
+	c := e.Container
+	line := fmt.Sprintf("%s  %-8s  %s  user=%d app=%s", e.Time.Local().Format(time.DateTime), e.Type, c.Name, c.User, c.App)
+	if c.Reason != "" {
+		line += fmt.Sprintf(" reason=%q", c.Reason)
+	}
+	if e.Type == docker.EventCreated || e.Type == docker.EventExtended {
+		line += " deadline=" + c.Deadline.Format(time.DateTime)
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// ListRemoveResults shows the containers selected by a bulk removal, and whether each one was removed.
+func ListRemoveResults(w io.Writer, dryRun bool, results []docker.ContainerActionError) error {
+	table := makeTable(w)
+	table.SetHeader([]string{"Name", "User", "App", "State", "Result"})
+	for _, r := range results {
+		result := "removed"
+		switch {
+		case dryRun:
+			result = "would remove"
+		case r.Err != nil:
+			result = r.Err.Error()
+		}
+		c := r.Container
+		table.Append([]string{c.Name, strconv.Itoa(c.User), c.App, c.State, result})
+	}
+	table.Render()
+	return nil
+}
+
 var ErrNotWrapped = errors.New("error not wrapped")
 
 func ListContainerActionErrors(w io.Writer, err error) error {
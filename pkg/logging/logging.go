@@ -0,0 +1,57 @@
+// Package logging configures the structured logger of the server.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// Setup configures the default logger from the "log" config key.
+func Setup(v *viper.Viper) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v.GetString("log.level"))); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+
+	var w io.Writer = os.Stderr
+	if path := v.GetString("log.file"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("log.file: %w", err)
+		}
+		w = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format := v.GetString("log.format"); format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("log.format: invalid format %q", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying the logger.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,133 @@
+// Package metrics defines the Prometheus metrics exported by the server.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "podzol"
+
+var (
+	operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Container operations by result.",
+	}, []string{"operation", "result"})
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of container operations, including image pulls and readiness checks.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"operation"})
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Failed container operations by error type.",
+	}, []string{"operation", "type"})
+	purged = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purged_containers_total",
+		Help:      "Expired containers removed by purge.",
+	})
+
+	proxyConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "connections_total",
+		Help:      "Connections accepted by the HTTP proxy.",
+	})
+	proxyActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "active_connections",
+		Help:      "Connections currently open on the HTTP proxy.",
+	})
+	proxyBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "bytes_total",
+		Help:      "Bytes relayed by the HTTP proxy, upstream (to containers) and downstream (to clients).",
+	}, []string{"direction"})
+	proxyUpstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "upstream_errors_total",
+		Help:      "Connections the HTTP proxy could not route, by stage (lookup or dial).",
+	}, []string{"stage"})
+)
+
+// Register registers the metrics, along with the Go runtime and process collectors.
+func Register(r prometheus.Registerer) {
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		operations,
+		operationDuration,
+		errorsTotal,
+		purged,
+		proxyConnections,
+		proxyActive,
+		proxyBytes,
+		proxyUpstreamErrors,
+	)
+}
+
+// ObserveOperation records the outcome and latency of a container operation.
+// errorType classifies err, and is ignored if err is nil.
+func ObserveOperation(operation string, start time.Time, err error, errorType string) {
+	operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		operations.WithLabelValues(operation, "error").Inc()
+		errorsTotal.WithLabelValues(operation, errorType).Inc()
+		return
+	}
+	operations.WithLabelValues(operation, "success").Inc()
+}
+
+// Purged counts containers removed by purge.
+func Purged(n int) {
+	purged.Add(float64(n))
+}
+
+// ProxyConnection counts a new proxy connection, returning a function to call when it closes.
+func ProxyConnection() (done func(up, down int64)) {
+	proxyConnections.Inc()
+	proxyActive.Inc()
+	return func(up, down int64) {
+		proxyActive.Dec()
+		proxyBytes.WithLabelValues("upstream").Add(float64(up))
+		proxyBytes.WithLabelValues("downstream").Add(float64(down))
+	}
+}
+
+// ProxyUpstreamError counts a proxy connection that failed at the given stage.
+func ProxyUpstreamError(stage string) {
+	proxyUpstreamErrors.WithLabelValues(stage).Inc()
+}
+
+// ContainerCollector reports running containers by application, counted at scrape time.
+type ContainerCollector struct {
+	count func() map[string]int
+	desc  *prometheus.Desc
+}
+
+// NewContainerCollector creates a collector from a function counting running containers by application.
+func NewContainerCollector(count func() map[string]int) *ContainerCollector {
+	return &ContainerCollector{
+		count: count,
+		desc:  prometheus.NewDesc(namespace+"_containers_running", "Running containers by application.", []string{"app"}, nil),
+	}
+}
+
+func (c *ContainerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *ContainerCollector) Collect(ch chan<- prometheus.Metric) {
+	for app, n := range c.count() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), app)
+	}
+}
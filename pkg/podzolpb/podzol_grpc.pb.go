@@ -0,0 +1,337 @@
+// Management API of podzol over gRPC, mirroring the HTTP JSON API.
+// Regenerate the Go code with `make proto`.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: podzol.proto
+
+package podzolpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Podzol_Create_FullMethodName = "/podzol.v1.Podzol/Create"
+	Podzol_Remove_FullMethodName = "/podzol.v1.Podzol/Remove"
+	Podzol_List_FullMethodName   = "/podzol.v1.Podzol/List"
+	Podzol_Purge_FullMethodName  = "/podzol.v1.Podzol/Purge"
+	Podzol_Extend_FullMethodName = "/podzol.v1.Podzol/Extend"
+	Podzol_Watch_FullMethodName  = "/podzol.v1.Podzol/Watch"
+)
+
+// PodzolClient is the client API for Podzol service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PodzolClient interface {
+	// Create a container. If it already exists, the create policy of the app decides the outcome.
+	Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Container, error)
+	// Remove a container.
+	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	// List containers, optionally filtered by user and app.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Remove all expired containers.
+	Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeResponse, error)
+	// Change the deadline of a running container.
+	Extend(ctx context.Context, in *ExtendRequest, opts ...grpc.CallOption) (*Container, error)
+	// Stream lifecycle events of containers until the call is cancelled.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Podzol_WatchClient, error)
+}
+
+type podzolClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPodzolClient(cc grpc.ClientConnInterface) PodzolClient {
+	return &podzolClient{cc}
+}
+
+func (c *podzolClient) Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Container, error) {
+	out := new(Container)
+	err := c.cc.Invoke(ctx, Podzol_Create_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *podzolClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error) {
+	out := new(RemoveResponse)
+	err := c.cc.Invoke(ctx, Podzol_Remove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *podzolClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, Podzol_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *podzolClient) Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeResponse, error) {
+	out := new(PurgeResponse)
+	err := c.cc.Invoke(ctx, Podzol_Purge_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *podzolClient) Extend(ctx context.Context, in *ExtendRequest, opts ...grpc.CallOption) (*Container, error) {
+	out := new(Container)
+	err := c.cc.Invoke(ctx, Podzol_Extend_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *podzolClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Podzol_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Podzol_ServiceDesc.Streams[0], Podzol_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &podzolWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Podzol_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type podzolWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *podzolWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PodzolServer is the server API for Podzol service.
+// All implementations must embed UnimplementedPodzolServer
+// for forward compatibility
+type PodzolServer interface {
+	// Create a container. If it already exists, the create policy of the app decides the outcome.
+	Create(context.Context, *CreateRequest) (*Container, error)
+	// Remove a container.
+	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	// List containers, optionally filtered by user and app.
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Remove all expired containers.
+	Purge(context.Context, *PurgeRequest) (*PurgeResponse, error)
+	// Change the deadline of a running container.
+	Extend(context.Context, *ExtendRequest) (*Container, error)
+	// Stream lifecycle events of containers until the call is cancelled.
+	Watch(*WatchRequest, Podzol_WatchServer) error
+	mustEmbedUnimplementedPodzolServer()
+}
+
+// UnimplementedPodzolServer must be embedded to have forward compatible implementations.
+type UnimplementedPodzolServer struct {
+}
+
+func (UnimplementedPodzolServer) Create(context.Context, *CreateRequest) (*Container, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Create not implemented")
+}
+func (UnimplementedPodzolServer) Remove(context.Context, *RemoveRequest) (*RemoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedPodzolServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedPodzolServer) Purge(context.Context, *PurgeRequest) (*PurgeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Purge not implemented")
+}
+func (UnimplementedPodzolServer) Extend(context.Context, *ExtendRequest) (*Container, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Extend not implemented")
+}
+func (UnimplementedPodzolServer) Watch(*WatchRequest, Podzol_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedPodzolServer) mustEmbedUnimplementedPodzolServer() {}
+
+// UnsafePodzolServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PodzolServer will
+// result in compilation errors.
+type UnsafePodzolServer interface {
+	mustEmbedUnimplementedPodzolServer()
+}
+
+func RegisterPodzolServer(s grpc.ServiceRegistrar, srv PodzolServer) {
+	s.RegisterService(&Podzol_ServiceDesc, srv)
+}
+
+func _Podzol_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PodzolServer).Create(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Podzol_Create_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PodzolServer).Create(ctx, req.(*CreateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Podzol_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PodzolServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Podzol_Remove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PodzolServer).Remove(ctx, req.(*RemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Podzol_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PodzolServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Podzol_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PodzolServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Podzol_Purge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PodzolServer).Purge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Podzol_Purge_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PodzolServer).Purge(ctx, req.(*PurgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Podzol_Extend_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PodzolServer).Extend(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Podzol_Extend_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PodzolServer).Extend(ctx, req.(*ExtendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Podzol_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PodzolServer).Watch(m, &podzolWatchServer{stream})
+}
+
+type Podzol_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type podzolWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *podzolWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Podzol_ServiceDesc is the grpc.ServiceDesc for Podzol service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Podzol_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "podzol.v1.Podzol",
+	HandlerType: (*PodzolServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Create",
+			Handler:    _Podzol_Create_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _Podzol_Remove_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Podzol_List_Handler,
+		},
+		{
+			MethodName: "Purge",
+			Handler:    _Podzol_Purge_Handler,
+		},
+		{
+			MethodName: "Extend",
+			Handler:    _Podzol_Extend_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Podzol_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "podzol.proto",
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/ustclug/podzol/pkg/audit"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/logging"
+	"github.com/ustclug/podzol/pkg/utils"
+)
+
+// auditEntry starts an audit log entry for an action on the container selected by opts.
+func (s *Server) auditEntry(r *http.Request, action string, opts docker.ContainerOptions) audit.Entry {
+	e := s.newAuditEntry(r.Context(), r.RemoteAddr, action, opts)
+	e.ForwardedFor = r.Header.Get("X-Forwarded-For")
+	return e
+}
+
+// newAuditEntry starts an audit log entry for an action by the caller identified in ctx, connected from source.
+func (s *Server) newAuditEntry(ctx context.Context, source, action string, opts docker.ContainerOptions) audit.Entry {
+	e := audit.Entry{
+		Action:   action,
+		Identity: IdentityFrom(ctx).Name,
+		Source:   source,
+		User:     opts.User,
+		App:      opts.AppName,
+	}
+	// Drop the port, but keep the peer credentials of Unix socket connections
+	if _, unix := PeerFrom(ctx); !unix {
+		if host, _, err := net.SplitHostPort(source); err == nil {
+			e.Source = host
+		}
+	}
+	if opts.AppName != "" {
+		e.Container = s.docker.ContainerName(opts)
+	}
+	return e
+}
+
+// record completes the entry with the outcome of the action, and appends it to the audit log.
+// Failures to write the audit log are logged, but do not fail the action.
+func (s *Server) record(ctx context.Context, e audit.Entry, err error) {
+	e.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		e.Outcome = audit.OutcomeFailure
+		e.Error = err.Error()
+	}
+	if err := s.auditLog.Append(e); err != nil {
+		logging.FromContext(ctx).Error("failed to write audit log", "action", e.Action, "error", err)
+	}
+}
+
+// recordContainer records the outcome of an action, along with the resulting container if there is one,
+// as for a container failing its readiness check.
+func (s *Server) recordContainer(ctx context.Context, e audit.Entry, info docker.ContainerInfo, err error) {
+	if info.Name != "" {
+		e.Container, e.ContainerID = info.Name, info.ID
+	}
+	s.record(ctx, e, err)
+}
+
+// actionErrors returns the errors of the individual containers from a joined error.
+func actionErrors(err error) []docker.ContainerActionError {
+	errs := make([]docker.ContainerActionError, 0)
+	for _, err := range utils.UnwrapErrors(err) {
+		var actionErr docker.ContainerActionError
+		if errors.As(err, &actionErr) {
+			errs = append(errs, actionErr)
+		}
+	}
+	return errs
+}
+
+// failedContainers maps the names of the containers in a joined error to their individual errors.
+func failedContainers(err error) map[string]error {
+	failed := make(map[string]error)
+	for _, actionErr := range actionErrors(err) {
+		failed[actionErr.Container.Name] = actionErr.Err
+	}
+	return failed
+}
+
+// recordEach records an entry for each container attempted by a purge or bulk removal, starting from base.
+func (s *Server) recordEach(ctx context.Context, base audit.Entry, containers []docker.ContainerInfo, err error) {
+	failed := failedContainers(err)
+	if len(containers) == 0 && err != nil {
+		// Nothing was attempted
+		s.record(ctx, base, err)
+		return
+	}
+	for _, info := range containers {
+		e := base
+		e.User, e.App = info.User, info.App
+		e.Container, e.ContainerID = info.Name, info.ID
+		s.record(ctx, e, failed[info.Name])
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Scopes granted to API keys.
+const (
+	// ScopeUser grants access to the regular management API.
+	ScopeUser = "user"
+	// ScopeAdmin grants access to everything, including shell access to containers.
+	ScopeAdmin = "admin"
+)
+
+// Identity is the authenticated caller of the API.
+type Identity struct {
+	Name   string
+	Scopes []string
+}
+
+// Anonymous is the identity of all callers if neither API keys nor Unix socket peers are configured.
+// It is not granted ScopeAdmin, so that shell access to containers always requires configured credentials.
+var Anonymous = Identity{Name: "anonymous", Scopes: []string{ScopeUser}}
+
+// HasScope reports whether the identity has been granted the scope.
+func (id Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is an entry of the "api-keys" configuration, keyed by name.
+type APIKey struct {
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+func loadAPIKeys(v *viper.Viper) (map[string]APIKey, error) {
+	keys := make(map[string]APIKey)
+	if err := v.UnmarshalKey("api-keys", &keys); err != nil {
+		return nil, err
+	}
+	for name, key := range keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("api key %q: empty key", name)
+		}
+		for _, scope := range key.Scopes {
+			if scope != ScopeUser && scope != ScopeAdmin {
+				return nil, fmt.Errorf("api key %q: invalid scope %q", name, scope)
+			}
+		}
+	}
+	return keys, nil
+}
+
+type identityKey struct{}
+
+// IdentityFrom returns the identity of the caller from the request context.
+func IdentityFrom(ctx context.Context) Identity {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id
+}
+
+// authenticate identifies the caller of an HTTP request.
+func (s *Server) authenticate(r *http.Request) (Identity, bool) {
+	return s.identify(r.Context(), r.Header.Get("Authorization"))
+}
+
+// identify identifies the caller by its peer credentials on a Unix socket,
+// or by the bearer token of the Authorization header.
+func (s *Server) identify(ctx context.Context, authorization string) (Identity, bool) {
+	if peer, ok := PeerFrom(ctx); ok {
+		if id, ok := s.peerIdentity(peer); ok {
+			return id, true
+		}
+	}
+	if len(s.apiKeys) == 0 && len(s.unixPeers) == 0 {
+		return Anonymous, true
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return Identity{}, false
+	}
+	// Compare against all keys, so that timing does not reveal which one matched
+	var found Identity
+	for name, key := range s.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			found = Identity{Name: name, Scopes: key.Scopes}
+		}
+	}
+	return found, found.Name != ""
+}
+
+// require wraps a handler so that it is only accessible with the given scope.
+func (s *Server) require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !IdentityFrom(r.Context()).HasScope(scope) {
+			writeError(w, http.StatusForbidden, CodeForbidden, "scope "+scope+" required")
+			return
+		}
+		h(w, r)
+	}
+}
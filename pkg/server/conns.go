@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// connTracker serves raw connections from a listener and keeps track of them,
+// so that they can be drained on shutdown.
+type connTracker struct {
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+}
+
+// serve accepts connections from l and handles each in its own goroutine.
+// It returns nil once shutdown has been called.
+func (t *connTracker) serve(l net.Listener, handle func(net.Conn)) error {
+	t.mu.Lock()
+	if t.closing {
+		t.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	t.listener = l
+	t.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.closing {
+				return nil
+			}
+			return err
+		}
+		if !t.add(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer t.remove(conn)
+			handle(conn)
+		}()
+	}
+}
+
+func (t *connTracker) add(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+	t.wg.Done()
+}
+
+// shutdown stops accepting connections and waits for open ones to finish.
+// When ctx is done, the remaining connections are closed without waiting for their handlers,
+// and ctx.Err() is returned.
+func (t *connTracker) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closing = true
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// cooldown limits how often an action may be performed per user.
+type cooldown struct {
+	period time.Duration
+
+	mu   sync.Mutex
+	last map[int]time.Time
+}
+
+func newCooldown(period time.Duration) *cooldown {
+	return &cooldown{
+		period: period,
+		last:   make(map[int]time.Time),
+	}
+}
+
+// acquire records an attempt by the user.
+// If the user is still on cooldown, the remaining time is returned and nothing is recorded.
+func (c *cooldown) acquire(user int) time.Duration {
+	if c.period <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for u, t := range c.last {
+		if now.Sub(t) >= c.period {
+			delete(c.last, u)
+		}
+	}
+	if t, ok := c.last[user]; ok {
+		return c.period - now.Sub(t)
+	}
+	c.last[user] = now
+	return 0
+}
+
+// release forgets the last attempt by the user, e.g. because it failed.
+func (c *cooldown) release(user int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.last, user)
+}
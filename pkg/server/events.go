@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// eventsKeepalive is how often a comment is sent on idle event streams, so that proxies keep them open.
+const eventsKeepalive = 30 * time.Second
+
+// eventFilter selects events by the user and app of the container, and by type.
+type eventFilter struct {
+	opts  docker.ContainerOptions
+	types map[string]bool
+}
+
+// newEventFilter returns a filter for the user and app of opts, if set, and types, if any.
+func newEventFilter(opts docker.ContainerOptions, types []string) eventFilter {
+	f := eventFilter{opts: opts, types: make(map[string]bool)}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+func (f eventFilter) match(e docker.Event) bool {
+	switch {
+	case f.opts.User != 0 && e.Container.User != f.opts.User,
+		f.opts.AppName != "" && e.Container.App != f.opts.AppName,
+		len(f.types) > 0 && !f.types[e.Type]:
+		return false
+	}
+	return true
+}
+
+// Stream lifecycle events of containers as Server-Sent Events.
+// Query parameters: user, app, type (repeated). All are optional filters.
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := r.URL.Query()
+	var opts docker.ContainerOptions
+	if q.Get("user") != "" {
+		var err error
+		opts.User, err = strconv.Atoi(q.Get("user"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	opts.AppName = q.Get("app")
+	filter := newEventFilter(opts, q["type"])
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "streaming not supported")
+		return
+	}
+
+	ctx, cancel := s.streamContext(r.Context())
+	defer cancel()
+	annotateOptions(ctx, opts)
+	events, unsubscribe := s.docker.Subscribe(64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Disable response buffering of Nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.match(e) {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ustclug/podzol/pkg/audit"
+	"github.com/ustclug/podzol/pkg/docker"
+	"golang.org/x/net/websocket"
+)
+
+// Exec sessions are carried over a WebSocket in binary messages.
+// The first byte of each message is the channel, the rest is the payload.
+const (
+	// Client to server: standard input. An empty payload closes standard input.
+	ChannelStdin byte = iota
+	// Server to client: standard output, or the terminal if the session has a TTY.
+	ChannelStdout
+	// Server to client: standard error.
+	ChannelStderr
+	// Client to server: TerminalSize as JSON.
+	ChannelResize
+	// Server to client: ExecExit as JSON, sent once before the server closes the connection.
+	ChannelExit
+)
+
+// TerminalSize is the payload of ChannelResize.
+type TerminalSize struct {
+	Rows uint `json:"rows"`
+	Cols uint `json:"cols"`
+}
+
+// ExecExit is the payload of ChannelExit.
+type ExecExit struct {
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// channelWriter sends everything written to it on a channel of the WebSocket.
+type channelWriter struct {
+	ws      *websocket.Conn
+	channel byte
+}
+
+func (cw channelWriter) Write(b []byte) (int, error) {
+	msg := append([]byte{cw.channel}, b...)
+	if err := websocket.Message.Send(cw.ws, msg); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// sameOrigin rejects WebSocket handshakes from browsers on other sites.
+// Non-browser clients may omit the Origin header.
+func sameOrigin(config *websocket.Config, r *http.Request) error {
+	if r.Header.Get("Origin") == "" {
+		return nil
+	}
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return fmt.Errorf("origin %s not allowed", r.Header.Get("Origin"))
+	}
+	return nil
+}
+
+// sendExit sends the outcome of an exec session on ChannelExit.
+func sendExit(ws *websocket.Conn, exit ExecExit) {
+	b, _ := json.Marshal(exit)
+	_ = websocket.Message.Send(ws, append([]byte{ChannelExit}, b...))
+}
+
+// serveExec relays an exec session over the WebSocket until the command exits or the client goes away.
+func serveExec(ctx context.Context, ws *websocket.Conn, session *docker.ExecSession) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer ws.Close()
+		var exit ExecExit
+		err := session.Output(channelWriter{ws, ChannelStdout}, channelWriter{ws, ChannelStderr})
+		if err == nil {
+			exit.Code, err = session.ExitCode(ctx)
+		}
+		if err != nil {
+			exit.Error = err.Error()
+		}
+		sendExit(ws, exit)
+	}()
+
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			break
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case ChannelStdin:
+			if len(msg) == 1 {
+				_ = session.CloseWrite()
+				continue
+			}
+			_, _ = session.Write(msg[1:])
+		case ChannelResize:
+			var size TerminalSize
+			if err := json.Unmarshal(msg[1:], &size); err == nil {
+				_ = session.Resize(ctx, size.Rows, size.Cols)
+			}
+		}
+	}
+	session.Close()
+	<-done
+}
+
+// Run a command in a container, relaying its standard streams over a WebSocket.
+// Query parameters: user, app, cmd (repeated), tty, rows, cols, exec-user, env (repeated).
+func (s *Server) HandleExec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := r.URL.Query()
+	var opts docker.ContainerOptions
+	var execOpts docker.ExecOptions
+	var err error
+	opts.User, err = strconv.Atoi(q.Get("user"))
+	if err == nil {
+		execOpts.Tty, err = parseBool(q.Get("tty"), false)
+	}
+	if err == nil && q.Has("rows") {
+		execOpts.Rows, err = parseUint(q.Get("rows"))
+	}
+	if err == nil && q.Has("cols") {
+		execOpts.Cols, err = parseUint(q.Get("cols"))
+	}
+	if err == nil && len(q["cmd"]) == 0 {
+		err = fmt.Errorf("no command")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	opts.AppName = q.Get("app")
+	execOpts.Cmd = q["cmd"]
+	execOpts.User = q.Get("exec-user")
+	execOpts.Env = q["env"]
+
+	ctx := r.Context()
+	annotateOptions(ctx, opts)
+	annotate(ctx, "cmd", strings.Join(execOpts.Cmd, " "))
+	// Fail early if the container does not exist, while an HTTP status can still be sent
+	if _, err := s.docker.Inspect(ctx, opts); err != nil {
+		writeDockerError(w, "exec", err)
+		return
+	}
+
+	ws := websocket.Server{
+		Handshake: sameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			// Only run the command once the handshake has succeeded
+			session, err := s.docker.Exec(ctx, opts, execOpts)
+			e := s.auditEntry(r, audit.ActionExec, opts)
+			e.Detail = strings.Join(execOpts.Cmd, " ")
+			s.record(ctx, e, err)
+			if err != nil {
+				ws.PayloadType = websocket.BinaryFrame
+				sendExit(ws, ExecExit{Error: fmt.Sprintf("failed to exec: %v", err)})
+				ws.Close()
+				return
+			}
+			defer session.Close()
+			serveExec(ctx, ws, session)
+		},
+	}
+	ws.ServeHTTP(w, r)
+}
+
+func parseUint(s string) (uint, error) {
+	n, err := strconv.ParseUint(s, 10, 0)
+	return uint(n), err
+}
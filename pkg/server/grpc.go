@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/ustclug/podzol/pkg/audit"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/logging"
+	"github.com/ustclug/podzol/pkg/podzolpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcRequestID is the metadata key carrying the request ID, like RequestIDHeader.
+const grpcRequestID = "x-request-id"
+
+// newGRPCServer creates the gRPC server of the management API, sharing authentication,
+// request logging and auditing with the HTTP API.
+func (s *Server) newGRPCServer() *grpc.Server {
+	g := grpc.NewServer(
+		grpc.UnaryInterceptor(s.grpcUnary),
+		grpc.StreamInterceptor(s.grpcStream),
+	)
+	podzolpb.RegisterPodzolServer(g, &grpcService{s: s})
+	return g
+}
+
+// grpcContext authenticates a call and prepares its context for logging, like logRequests and ServeHTTP.
+// It returns the function logging the call once it has been served.
+func (s *Server) grpcContext(ctx context.Context, method string) (context.Context, func(error), error) {
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if v := md.Get(grpcRequestID); len(v) > 0 {
+		id = v[0]
+	}
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(grpcRequestID, id))
+
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	log := slog.With("request_id", id)
+	a := &annotations{}
+	ctx = logging.WithLogger(ctx, log)
+	ctx = context.WithValue(ctx, annotationsKey{}, a)
+	done := func(err error) {
+		code := status.Code(err)
+		level := slog.LevelInfo
+		switch code {
+		case codes.Internal, codes.Unavailable, codes.Unknown:
+			level = slog.LevelError
+		}
+		a.mu.Lock()
+		args := append([]any{
+			"method", method,
+			"code", code.String(),
+			"duration", time.Since(start),
+			"remote", remote,
+		}, a.args...)
+		a.mu.Unlock()
+		log.Log(ctx, level, "grpc request", args...)
+	}
+
+	authorization := ""
+	if v := md.Get("authorization"); len(v) > 0 {
+		authorization = v[0]
+	}
+	identity, ok := s.identify(ctx, authorization)
+	if !ok {
+		err := status.Error(codes.Unauthenticated, "invalid or missing API key")
+		done(err)
+		return nil, nil, err
+	}
+	annotate(ctx, "identity", identity.Name)
+	return context.WithValue(ctx, identityKey{}, identity), done, nil
+}
+
+func (s *Server) grpcUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, done, err := s.grpcContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (s *Server) grpcStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done, err := s.grpcContext(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	done(err)
+	return err
+}
+
+// serverStream replaces the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+// grpcError maps errors from the docker package to gRPC status errors, like writeDockerError.
+func grpcError(what string, err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, docker.ErrContainerExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, docker.ErrInvalidDeadline):
+		code = codes.InvalidArgument
+	case errors.Is(err, docker.ErrReadinessFailed):
+		code = codes.Unavailable
+	case errors.Is(err, docker.ErrNoStateFile):
+		code = codes.FailedPrecondition
+	case errdefs.IsNotFound(err):
+		code = codes.NotFound
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Errorf(code, "failed to %s: %v", what, err)
+}
+
+// grpcService implements the gRPC management API on top of the same docker client as the HTTP handlers.
+type grpcService struct {
+	podzolpb.UnimplementedPodzolServer
+	s *Server
+}
+
+// auditEntry starts an audit log entry for a call, like Server.auditEntry.
+func (g *grpcService) auditEntry(ctx context.Context, action string, opts docker.ContainerOptions) audit.Entry {
+	source := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		source = p.Addr.String()
+	}
+	return g.s.newAuditEntry(ctx, source, action, opts)
+}
+
+func (g *grpcService) Create(ctx context.Context, req *podzolpb.CreateRequest) (*podzolpb.Container, error) {
+	opts := docker.ContainerOptions{
+		User:     int(req.User),
+		Token:    req.Token,
+		AppName:  req.App,
+		Hostname: req.Hostname,
+		Image:    req.Image,
+		Lifetime: req.Lifetime.AsDuration(),
+	}
+	annotateOptions(ctx, opts)
+	info, err := g.s.docker.Create(ctx, opts)
+	if err == nil {
+		err = docker.CheckReadiness(info)
+	}
+	g.s.recordContainer(ctx, g.auditEntry(ctx, audit.ActionCreate, opts), info, err)
+	if err != nil {
+		return nil, grpcError("create container", err)
+	}
+	annotate(ctx, "container", info.Name)
+	return containerToPB(info), nil
+}
+
+func (g *grpcService) Remove(ctx context.Context, req *podzolpb.RemoveRequest) (*podzolpb.RemoveResponse, error) {
+	opts := docker.ContainerOptions{User: int(req.User), AppName: req.App}
+	annotateOptions(ctx, opts)
+	err := g.s.docker.Remove(ctx, opts)
+	g.s.record(ctx, g.auditEntry(ctx, audit.ActionRemove, opts), err)
+	if err != nil {
+		return nil, grpcError("remove container", err)
+	}
+	return &podzolpb.RemoveResponse{}, nil
+}
+
+func (g *grpcService) List(ctx context.Context, req *podzolpb.ListRequest) (*podzolpb.ListResponse, error) {
+	opts := docker.ContainerOptions{User: int(req.User), AppName: req.App}
+	annotateOptions(ctx, opts)
+	containers, err := g.s.docker.List(ctx, opts)
+	if err != nil {
+		return nil, grpcError("list containers", err)
+	}
+	resp := &podzolpb.ListResponse{}
+	for _, info := range containers {
+		resp.Containers = append(resp.Containers, containerToPB(info))
+	}
+	return resp, nil
+}
+
+func (g *grpcService) Purge(ctx context.Context, req *podzolpb.PurgeRequest) (*podzolpb.PurgeResponse, error) {
+	containers, err := g.s.docker.Purge(ctx)
+	g.s.recordEach(ctx, g.auditEntry(ctx, audit.ActionPurge, docker.ContainerOptions{}), containers, err)
+	if containers == nil && err != nil {
+		return nil, grpcError("purge containers", err)
+	}
+	resp := &podzolpb.PurgeResponse{}
+	for _, info := range containers {
+		resp.Containers = append(resp.Containers, containerToPB(info))
+	}
+	for _, e := range actionErrors(err) {
+		resp.Errors = append(resp.Errors, &podzolpb.ContainerError{
+			Action:    e.Action,
+			Container: containerToPB(e.Container),
+			Error:     e.Err.Error(),
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcService) Extend(ctx context.Context, req *podzolpb.ExtendRequest) (*podzolpb.Container, error) {
+	opts := docker.ContainerOptions{User: int(req.User), AppName: req.App}
+	annotateOptions(ctx, opts)
+	var deadline time.Time
+	switch change := req.Change.(type) {
+	case *podzolpb.ExtendRequest_Deadline:
+		deadline = change.Deadline.AsTime()
+	case *podzolpb.ExtendRequest_By:
+		info, err := g.s.docker.Inspect(ctx, opts)
+		if err != nil {
+			return nil, grpcError("inspect container", err)
+		}
+		deadline = info.Deadline.Add(change.By.AsDuration())
+	default:
+		return nil, status.Error(codes.InvalidArgument, "deadline or by is required")
+	}
+
+	info, err := g.s.docker.Extend(ctx, opts, deadline)
+	e := g.auditEntry(ctx, audit.ActionExtend, opts)
+	e.Detail = "deadline " + deadline.UTC().Format(time.RFC3339)
+	g.s.recordContainer(ctx, e, info, err)
+	if err != nil {
+		return nil, grpcError("extend container", err)
+	}
+	return containerToPB(info), nil
+}
+
+func (g *grpcService) Watch(req *podzolpb.WatchRequest, stream podzolpb.Podzol_WatchServer) error {
+	opts := docker.ContainerOptions{User: int(req.User), AppName: req.App}
+	filter := newEventFilter(opts, req.Types)
+	ctx, cancel := g.s.streamContext(stream.Context())
+	defer cancel()
+	annotateOptions(ctx, opts)
+	events, unsubscribe := g.s.docker.Subscribe(64)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Ending the stream is not an error, whether the client or the server did
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if !filter.match(e) {
+				continue
+			}
+			if err := stream.Send(eventToPB(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func containerToPB(info docker.ContainerInfo) *podzolpb.Container {
+	c := &podzolpb.Container{
+		Name:      info.Name,
+		Id:        info.ID,
+		Hostname:  info.Hostname,
+		Deadline:  timestamppb.New(info.Deadline),
+		User:      int64(info.User),
+		App:       info.App,
+		Port:      int32(info.Port),
+		Network:   info.Network,
+		Profile:   info.Profile,
+		State:     info.State,
+		Reason:    info.Reason,
+		Readiness: info.Readiness,
+		Terminal:  info.Terminal,
+	}
+	if info.Egress != nil {
+		c.Egress = &podzolpb.EgressPolicy{Mode: info.Egress.Mode, Network: info.Egress.Network}
+		for _, rule := range info.Egress.Allow {
+			c.Egress.Allow = append(c.Egress.Allow, &podzolpb.EgressRule{
+				Cidr:  rule.CIDR,
+				Port:  int32(rule.Port),
+				Proto: rule.Proto,
+			})
+		}
+	}
+	return c
+}
+
+func eventToPB(e docker.Event) *podzolpb.Event {
+	return &podzolpb.Event{
+		Type:      e.Type,
+		Time:      timestamppb.New(e.Time),
+		Container: containerToPB(e.Container),
+	}
+}
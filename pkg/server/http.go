@@ -5,13 +5,23 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/metrics"
 )
 
 type HTTPServer struct {
-	s *Server
+	s        *Server
+	terminal *connListener
+	// Serves web terminals on connections handed off by the proxy
+	terminalServer *http.Server
+	conns          connTracker
 }
 
 const BUFSIZE = 8192
@@ -38,46 +48,71 @@ func closeConn(conn *net.TCPConn) {
 
 // Create an HTTPServer from a Server.
 func (s *Server) HTTPServer() *HTTPServer {
-	return &HTTPServer{s}
+	return &HTTPServer{
+		s:              s,
+		terminal:       newConnListener(),
+		terminalServer: &http.Server{Handler: s.terminalHandler()},
+	}
 }
 
 func (s *HTTPServer) Handle(conn *net.TCPConn) {
 	defer conn.Close()
+	var uploadBytes, downloadBytes int64
+	done := metrics.ProxyConnection()
+	defer func() { done(uploadBytes, downloadBytes) }()
+	start := time.Now()
+	log := slog.With("remote", conn.RemoteAddr().String())
 
 	// Note: This is still very simple and contains lots of allocations.
 	r := bufio.NewReaderSize(conn, BUFSIZE)
 	buf := getBuffer()
-	bufi := 0
 	defer putBuffer(buf)
 	upstreamAddr := ""
+	terminal := false
 	for {
 		line, err := r.ReadSlice('\n')
 		if err != nil {
 			break
 		}
-		if bufi+len(line) > len(buf) {
+		if len(buf)+len(line) > cap(buf) {
 			// too large
 			closeConn(conn)
 			return
 		}
 
+		// accumulate to buf, to be replayed to upstream
+		buf = append(buf, line...)
+
 		if len(line) > 5 && bytes.EqualFold(line[:5], []byte("Host:")) {
 			hostname := string(bytes.TrimSpace(line[5:]))
 			hostname = strings.SplitN(hostname, ".", 2)[0]
-			upstreamAddr, err = s.s.docker.GetIP(context.TODO(), hostname)
+			log = log.With("hostname", hostname)
+			if info, ok := s.s.docker.Terminal(context.TODO(), hostname); ok {
+				log = log.With("container", info.Name, "user", info.User, "app", info.App)
+				terminal = true
+				break
+			}
+			var info docker.ContainerInfo
+			info, upstreamAddr, err = s.s.docker.Upstream(context.TODO(), hostname)
 			if err != nil {
+				metrics.ProxyUpstreamError("lookup")
+				log.Info("proxy lookup failed", "error", err)
 				closeConn(conn)
 				return
 			}
+			log = log.With("container", info.Name, "user", info.User, "app", info.App, "upstream", upstreamAddr)
 			break
 		}
 		if len(line) < 2 || bytes.Equal(line, []byte("\r\n")) {
 			// all headers read
 			break
 		}
-
-		// accumulate to buf
-		bufi += copy(buf[bufi:], line)
+	}
+	if terminal {
+		// Serve the web terminal instead of proxying
+		s.terminal.handoff(conn, io.MultiReader(bytes.NewReader(buf), r))
+		log.Info("terminal connection", "duration", time.Since(start))
+		return
 	}
 	if upstreamAddr == "" {
 		closeConn(conn)
@@ -85,8 +120,10 @@ func (s *HTTPServer) Handle(conn *net.TCPConn) {
 	}
 
 	// Connect to upstream
-	upstreamConnTemp, err := net.Dial("tcp", upstreamAddr+":8080")
+	upstreamConnTemp, err := net.Dial("tcp", upstreamAddr)
 	if err != nil {
+		metrics.ProxyUpstreamError("dial")
+		log.Warn("proxy dial failed", "error", err)
 		closeConn(conn)
 		return
 	}
@@ -94,14 +131,20 @@ func (s *HTTPServer) Handle(conn *net.TCPConn) {
 	defer upstreamConn.Close()
 	defer conn.Close()
 
+	// Replay what has been read so far
+	if _, err := upstreamConn.Write(buf); err != nil {
+		return
+	}
+
 	chUp := make(chan int64)
 	chDown := make(chan int64)
 	go func() {
 		// copy from conn to upstream
-		n, err := io.Copy(upstreamConn, conn)
+		n, err := io.Copy(upstreamConn, r)
 		if err != nil {
-			// TODO: log error
-			_ = err
+			log.Debug("proxy copy to upstream", "error", err)
+			// Also stop waiting for upstream, e.g. if the connection was closed on shutdown
+			upstreamConn.Close()
 		}
 		chUp <- n
 	}()
@@ -109,27 +152,32 @@ func (s *HTTPServer) Handle(conn *net.TCPConn) {
 		// copy from upstream to conn
 		n, err := io.Copy(conn, upstreamConn)
 		if err != nil {
-			// TODO: log error
-			_ = err
+			log.Debug("proxy copy from upstream", "error", err)
 		}
 		chDown <- n
 	}()
 
 	// Collect statistics
-	uploadBytes := <-chUp
-	downloadBytes := <-chDown
-	// TODO: print or log them?
-	_, _ = uploadBytes, downloadBytes
+	uploadBytes = <-chUp
+	downloadBytes = <-chDown
+	log.Info("proxy connection", "bytes_up", uploadBytes, "bytes_down", downloadBytes, "duration", time.Since(start))
 }
 
+// Serve proxies connections accepted from l. It returns nil after Shutdown.
 func (s *HTTPServer) Serve(l net.Listener) error {
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			return err
-		}
-		go s.Handle(conn.(*net.TCPConn))
-	}
+	go s.terminalServer.Serve(s.terminal)
+	defer s.terminal.Close()
+	return s.conns.serve(l, func(conn net.Conn) {
+		s.Handle(conn.(*net.TCPConn))
+	})
+}
+
+// Shutdown stops accepting connections and waits for proxied connections and terminal sessions to finish.
+// When ctx is done, the remaining connections are closed.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	// Close idle keep-alive connections of terminal pages, which would hold up their proxy connections
+	go s.terminalServer.Shutdown(ctx)
+	return s.conns.shutdown(ctx)
 }
 
 func (s *HTTPServer) ListenAndServe() error {
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyHeader carries a client-generated key, so that retried requests are not performed twice.
+const IdempotencyHeader = "Idempotency-Key"
+
+// idempotentResponse is a recorded response, replayed for requests with the same key.
+type idempotentResponse struct {
+	done    chan struct{}
+	digest  [sha256.Size]byte
+	created time.Time
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyStore records responses by idempotency key.
+type idempotencyStore struct {
+	retention time.Duration
+
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+func newIdempotencyStore(retention time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		retention: retention,
+		responses: make(map[string]*idempotentResponse),
+	}
+}
+
+// acquire returns the response for the key, and whether the caller is responsible for producing it.
+func (st *idempotencyStore) acquire(key string, digest [sha256.Size]byte) (*idempotentResponse, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k, resp := range st.responses {
+		if time.Since(resp.created) > st.retention {
+			delete(st.responses, k)
+		}
+	}
+
+	if resp, ok := st.responses[key]; ok {
+		return resp, false
+	}
+	resp := &idempotentResponse{
+		done:    make(chan struct{}),
+		digest:  digest,
+		created: time.Now(),
+	}
+	st.responses[key] = resp
+	return resp, true
+}
+
+// forget drops the key, so that the request can be retried.
+func (st *idempotencyStore) forget(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.responses, key)
+}
+
+// recorder captures a response while writing it through.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.header = r.ResponseWriter.Header().Clone()
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.header == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent wraps a handler so that requests carrying an Idempotency-Key header are performed at most once.
+// Requests with a known key get the recorded response. Requests that are still in progress are waited for.
+// Keys are scoped to the identity of the caller, so that callers cannot see each other's responses.
+// Server errors are not recorded, so the request may be retried.
+func (s *Server) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			h(w, r)
+			return
+		}
+		key = IdentityFrom(r.Context()).Name + "\x00" + key
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		digest := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+
+		resp, owner := s.idempotency.acquire(key, digest)
+		if !owner {
+			if resp.digest != digest {
+				writeError(w, http.StatusUnprocessableEntity, CodeIdempotencyMismatch, "idempotency key reused with a different request")
+				return
+			}
+			select {
+			case <-resp.done:
+			case <-r.Context().Done():
+				return
+			}
+			if resp.header == nil {
+				// The original request failed and has been forgotten
+				writeError(w, http.StatusConflict, CodeConflict, "concurrent request with the same idempotency key failed")
+				return
+			}
+			for k, v := range resp.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.status)
+			_, _ = w.Write(resp.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		defer close(resp.done)
+		h(rec, r)
+		if rec.header == nil || rec.status >= http.StatusInternalServerError {
+			s.idempotency.forget(key)
+			return
+		}
+		resp.status = rec.status
+		resp.header = rec.header
+		resp.body = rec.body.Bytes()
+	}
+}
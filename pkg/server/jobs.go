@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/audit"
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/logging"
+)
+
+// Job is the status of an asynchronous container creation.
+type Job struct {
+	ID        string                `json:"id"`
+	Phase     string                `json:"phase"`
+	Error     string                `json:"error,omitempty"`
+	Container *docker.ContainerInfo `json:"container,omitempty"`
+
+	opts     docker.ContainerOptions
+	audit    audit.Entry
+	finished time.Time
+	// Final phase reported by the readiness check before the container was recorded
+	checked string
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (j Job) Done() bool {
+	return j.Phase == docker.PhaseReady || j.Phase == docker.PhaseFailed
+}
+
+var (
+	ErrQueueFull    = errors.New("create queue is full")
+	ErrShuttingDown = errors.New("server is shutting down")
+)
+
+// jobQueue runs container creations in the background.
+type jobQueue struct {
+	s         *Server
+	queue     chan *Job
+	retention time.Duration
+
+	stop    chan struct{}
+	abort   chan struct{}
+	workers sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	stopped bool
+}
+
+func newJobQueue(s *Server, size int, retention time.Duration) *jobQueue {
+	return &jobQueue{
+		s:         s,
+		queue:     make(chan *Job, size),
+		retention: retention,
+		stop:      make(chan struct{}),
+		abort:     make(chan struct{}),
+		jobs:      make(map[string]*Job),
+	}
+}
+
+// run starts the given number of workers, which stop when ctx is done,
+// or on shutdown once the queue is empty.
+func (q *jobQueue) run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			for {
+				// Leave the queued jobs to shutdown once it gives up waiting
+				select {
+				case <-q.abort:
+					return
+				default:
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-q.abort:
+					return
+				case job := <-q.queue:
+					q.process(ctx, job)
+				case <-q.stop:
+					select {
+					case job := <-q.queue:
+						q.process(ctx, job)
+					default:
+						return
+					}
+				}
+			}
+		}()
+	}
+}
+
+func (q *jobQueue) process(ctx context.Context, job *Job) {
+	log := slog.With("job", job.ID, "user", job.opts.User, "app", job.opts.AppName)
+	ctx = logging.WithLogger(ctx, log)
+	ctx = docker.WithProgress(ctx, func(phase string) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		switch {
+		case phase != docker.PhaseReady && phase != docker.PhaseFailed:
+			job.Phase = phase
+		case job.Container == nil:
+			// The readiness check completed before Create returned
+			job.checked = phase
+		default:
+			// The asynchronous readiness check completed
+			job.complete(phase)
+		}
+	})
+	info, err := q.s.docker.Create(ctx, job.opts)
+	entry := job.audit
+	entry.Detail = "job " + job.ID
+	outcome := err
+	if err == nil {
+		// Only a synchronous readiness check has completed by now
+		outcome = docker.CheckReadiness(info)
+	}
+	q.s.recordContainer(ctx, entry, info, outcome)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		log.Warn("asynchronous create failed", "error", err)
+		job.finished = time.Now()
+		job.Phase = docker.PhaseFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Container = &info
+	switch {
+	case info.Readiness == docker.ReadinessPending && job.checked == "":
+		// Completed once the asynchronous readiness check completes
+	case info.Readiness == docker.ReadinessPending:
+		job.complete(job.checked)
+	case info.Readiness == docker.ReadinessFailed:
+		job.complete(docker.PhaseFailed)
+	default:
+		job.complete(docker.PhaseReady)
+	}
+}
+
+// complete records the final phase of a job whose container has been created,
+// updating the readiness of the container if its check was still pending.
+// The caller must hold the lock.
+func (j *Job) complete(phase string) {
+	j.Phase = phase
+	j.finished = time.Now()
+	info := *j.Container
+	if info.Readiness == docker.ReadinessPending {
+		info.Readiness = docker.ReadinessReady
+		if phase == docker.PhaseFailed {
+			info.Readiness = docker.ReadinessFailed
+		}
+	}
+	j.Container = &info
+	if err := docker.CheckReadiness(info); err != nil {
+		j.Error = err.Error()
+	}
+}
+
+// shutdown stops accepting jobs, and waits for queued and running ones to finish until ctx is done.
+// Jobs still queued then fail, and are recorded in the audit log.
+func (q *jobQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopped = true
+	q.mu.Unlock()
+	close(q.stop)
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	close(q.abort)
+	for {
+		select {
+		case job := <-q.queue:
+			q.fail(ctx, job, ErrShuttingDown)
+		default:
+			return err
+		}
+	}
+}
+
+// fail records a job that failed before its container could be created.
+func (q *jobQueue) fail(ctx context.Context, job *Job, err error) {
+	slog.Warn("asynchronous create failed", "job", job.ID, "user", job.opts.User, "app", job.opts.AppName, "error", err)
+	entry := job.audit
+	entry.Detail = "job " + job.ID
+	q.s.record(ctx, entry, err)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.finished = time.Now()
+	job.Phase = docker.PhaseFailed
+	job.Error = err.Error()
+}
+
+// submit queues a container creation and returns the new job.
+// The audit entry is recorded once the creation has finished.
+func (q *jobQueue) submit(opts docker.ContainerOptions, entry audit.Entry) (Job, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		ID:    hex.EncodeToString(b),
+		Phase: docker.PhaseQueued,
+		opts:  opts,
+		audit: entry,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return Job{}, ErrShuttingDown
+	}
+	q.prune()
+	select {
+	case q.queue <- job:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	return *job, nil
+}
+
+// get returns a copy of the job.
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// len returns the number of jobs waiting in the queue.
+func (q *jobQueue) len() int {
+	return len(q.queue)
+}
+
+// prune drops finished jobs older than the retention period.
+// The caller must hold the lock.
+func (q *jobQueue) prune() {
+	for id, job := range q.jobs {
+		if job.Done() && time.Since(job.finished) > q.retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Get the status of an asynchronous creation.
+func (s *Server) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	s.getJob(w, strings.TrimPrefix(r.URL.Path, "/jobs/"))
+}
+
+// getJob responds with the status of the job.
+func (s *Server) getJob(w http.ResponseWriter, id string) {
+	job, ok := s.jobs.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "job not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/systemd"
+)
+
+// Names of the listeners of the server, as passed on a handoff.
+const (
+	listenerAPI     = "api"
+	listenerProxy   = "proxy"
+	listenerMetrics = "metrics"
+	listenerSSH     = "ssh"
+	listenerGRPC    = "grpc"
+)
+
+// handoffEnv lists the names of the files passed to a new process on a handoff, starting at fd 3.
+// The last one, handoffReady, is a pipe to report readiness on.
+const (
+	handoffEnv   = "PODZOL_HANDOFF_FDS"
+	handoffReady = "ready"
+)
+
+// handoffTimeout is how long a new process may take to become ready after a handoff.
+const handoffTimeout = 2 * time.Minute
+
+// inheritFiles takes over the listeners passed by systemd, or by the parent process on a handoff.
+func (s *Server) inheritFiles() error {
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return err
+	}
+	for name, l := range activated {
+		switch name {
+		case listenerAPI, listenerProxy, listenerMetrics, listenerSSH, listenerGRPC:
+			s.inherited[name] = l
+		default:
+			slog.Warn("ignoring socket with unknown FileDescriptorName", "name", name, "addr", l.Addr().String())
+			l.Close()
+		}
+	}
+
+	names := os.Getenv(handoffEnv)
+	if names == "" {
+		return nil
+	}
+	os.Unsetenv(handoffEnv)
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		if name == handoffReady {
+			s.readyPipe = f
+			continue
+		}
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("inherited listener %s: %w", name, err)
+		}
+		s.inherited[name] = l
+	}
+	return nil
+}
+
+// listen returns the inherited listener with the given name, or listens on addr.
+func (s *Server) listen(name, addr string) (net.Listener, error) {
+	if l, ok := s.inherited[name]; ok {
+		delete(s.inherited, name)
+		slog.Info("using inherited listener", "listener", name, "addr", l.Addr().String())
+		return l, nil
+	}
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		if name != listenerAPI {
+			return nil, fmt.Errorf("%s: Unix sockets are only supported for the API", name)
+		}
+		return s.listenUnix(path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Listen opens the listeners of all enabled services, so that they are ready to be served by
+// Run, RunHTTP, RunMetrics, RunSSH and RunGRPC.
+func (s *Server) Listen() error {
+	if err := s.inheritFiles(); err != nil {
+		return err
+	}
+	type listener struct {
+		name, addr string
+		enabled    bool
+	}
+	for _, l := range []listener{
+		{listenerAPI, s.listenAddr, true},
+		{listenerProxy, s.httpAddr, true},
+		{listenerMetrics, s.metricsAddr, s.MetricsEnabled()},
+		{listenerSSH, s.sshAddr, s.SSHEnabled()},
+		{listenerGRPC, s.grpcAddr, s.GRPCEnabled()},
+	} {
+		if !l.enabled {
+			continue
+		}
+		nl, err := s.listen(l.name, l.addr)
+		if err != nil {
+			return err
+		}
+		s.listeners[l.name] = nl
+	}
+	if s.SSHEnabled() {
+		g, err := s.SSHServer()
+		if err != nil {
+			return err
+		}
+		s.ssh = g
+	}
+	// Listeners of services that have been disabled since the handoff
+	for name, l := range s.inherited {
+		l.Close()
+		delete(s.inherited, name)
+	}
+	return nil
+}
+
+// Ready tells the parent process, if any, that the server has taken over after a handoff.
+func (s *Server) Ready() error {
+	if s.readyPipe == nil {
+		return nil
+	}
+	defer func() {
+		s.readyPipe.Close()
+		s.readyPipe = nil
+	}()
+	_, err := s.readyPipe.Write([]byte(handoffReady + "\n"))
+	return err
+}
+
+// Handoff starts a new server process with the same arguments, passing the listeners to it.
+// It returns once the new process is ready, after which this one should shut down.
+// On error, the new process is killed and this one keeps serving.
+func (s *Server) Handoff() error {
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for name, l := range s.listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s cannot be passed on", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	names = append(names, handoffReady)
+	files = append(files, w)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// The new process becomes the main process of the service, and takes over the systemd watchdog
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "WATCHDOG_PID=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, handoffEnv+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Only the new process holds the write end now, so that reading fails if it exits
+	w.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, len(handoffReady)+1)
+		_, err := io.ReadFull(r, b)
+		if err == nil && string(b) != handoffReady+"\n" {
+			err = errors.New("unexpected message")
+		}
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(handoffTimeout):
+		err = fmt.Errorf("not ready after %s", handoffTimeout)
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("new process failed to take over: %w", err)
+	}
+	slog.Info("handed off listeners", "pid", cmd.Process.Pid)
+	// The new process outlives this one, and is reaped by init
+	_ = cmd.Process.Release()
+	s.handedOff = true
+	return nil
+}
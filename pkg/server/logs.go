@@ -0,0 +1,95 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// MediaMultiplexed is the media type of logs with stdout and stderr framed as by Docker,
+// sent to clients accepting it.
+const MediaMultiplexed = "application/vnd.docker.multiplexed-stream"
+
+// flushWriter flushes after every write, for streaming responses.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) io.Writer {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return w
+	}
+	return flushWriter{w, f}
+}
+
+func (fw flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.w.Write(b)
+	fw.f.Flush()
+	return n, err
+}
+
+// parseBool parses an optional boolean query parameter.
+func parseBool(s string, def bool) (bool, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// Stream the logs of a container as plain text.
+// Query parameters: user, app, follow, tail, since, stdout, stderr.
+func (s *Server) HandleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := r.URL.Query()
+	var opts docker.ContainerOptions
+	var logOpts docker.LogOptions
+	var err error
+	opts.User, err = strconv.Atoi(q.Get("user"))
+	if err == nil {
+		logOpts.Follow, err = parseBool(q.Get("follow"), false)
+	}
+	if err == nil {
+		logOpts.Stdout, err = parseBool(q.Get("stdout"), true)
+	}
+	if err == nil {
+		logOpts.Stderr, err = parseBool(q.Get("stderr"), true)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	opts.AppName = q.Get("app")
+	logOpts.Tail = q.Get("tail")
+	logOpts.Since = q.Get("since")
+
+	ctx, cancel := s.streamContext(r.Context())
+	defer cancel()
+	annotateOptions(ctx, opts)
+	logs, err := s.docker.Logs(ctx, opts, logOpts)
+	if err != nil {
+		writeDockerError(w, "get logs", err)
+		return
+	}
+	defer logs.Close()
+
+	fw := newFlushWriter(w)
+	if strings.Contains(r.Header.Get("Accept"), MediaMultiplexed) {
+		w.Header().Set("Content-Type", MediaMultiplexed)
+		w.WriteHeader(http.StatusOK)
+		_ = logs.Copy(stdcopy.NewStdWriter(fw, stdcopy.Stdout), stdcopy.NewStdWriter(fw, stdcopy.Stderr))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_ = logs.Copy(fw, fw)
+}
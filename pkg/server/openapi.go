@@ -0,0 +1,446 @@
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ustclug/podzol/pkg"
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// schema is a JSON schema object of an OpenAPI document.
+type schema = map[string]any
+
+// schemaOverrides gives the schemas of fields with a custom JSON encoding, by type and JSON name.
+// Every type implementing json.Marshaler must be listed, so that the generated document
+// cannot silently diverge from what the server sends.
+var schemaOverrides = map[reflect.Type]map[string]schema{
+	reflect.TypeOf(docker.ContainerOptions{}): {
+		"lifetime": {
+			"description": "How long until the container expires, in seconds or as a duration string such as \"1h30m\"",
+			"oneOf":       []any{schema{"type": "number"}, schema{"type": "string"}},
+		},
+	},
+	reflect.TypeOf(docker.ContainerInfo{}): {
+		"deadline": {"type": "integer", "format": "int64", "description": "When the container expires, as a Unix timestamp"},
+	},
+	reflect.TypeOf(docker.ContainerActionError{}): {
+		"error": {"type": "string"},
+	},
+}
+
+// optionalFields lists types whose fields are never required as a whole,
+// because what is required depends on the endpoint.
+var optionalFields = map[reflect.Type]bool{
+	reflect.TypeOf(docker.ContainerOptions{}): true,
+	reflect.TypeOf(RemoveRequest{}):           true,
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaGenerator derives schemas from Go types by reflection, the way encoding/json encodes them.
+// Named struct types become components, referenced by their name.
+type schemaGenerator struct {
+	components map[string]schema
+	types      map[string]reflect.Type
+}
+
+// ref returns the schema of a value of type t.
+func (g *schemaGenerator) ref(t reflect.Type) (schema, error) {
+	switch {
+	case t == timeType:
+		return schema{"type": "string", "format": "date-time"}, nil
+	case t.Kind() == reflect.Pointer:
+		s, err := g.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return schema{"allOf": []any{s}, "nullable": true}, nil
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if err := g.component(t); err != nil {
+			return nil, err
+		}
+		return schema{"$ref": "#/components/schemas/" + t.Name()}, nil
+	}
+	return g.inline(t)
+}
+
+// inline returns the schema of an unnamed or non-struct type.
+func (g *schemaGenerator) inline(t reflect.Type) (schema, error) {
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return nil, fmt.Errorf("%s has a custom JSON encoding and no schema", t)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer"}, nil
+	case reflect.Int64, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64"}, nil
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}, nil
+	case reflect.String:
+		return schema{"type": "string"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return schema{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%s: map keys must be strings", t)
+		}
+		values, err := g.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return schema{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return g.object(t, nil)
+	default:
+		return nil, fmt.Errorf("%s: unsupported kind %s", t, t.Kind())
+	}
+}
+
+// component adds the schema of the named struct type t to the components.
+func (g *schemaGenerator) component(t reflect.Type) error {
+	name := t.Name()
+	if other, ok := g.types[name]; ok {
+		if other != t {
+			return fmt.Errorf("both %s and %s are named %s", other, t, name)
+		}
+		return nil
+	}
+	g.types[name] = t
+
+	overrides, ok := schemaOverrides[t]
+	if !ok && (t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)) {
+		return fmt.Errorf("%s has a custom JSON encoding and no schema overrides", t)
+	}
+	s, err := g.object(t, overrides)
+	if err != nil {
+		return err
+	}
+	g.components[name] = s
+	return nil
+}
+
+// object returns the schema of the fields of struct type t, replacing the overridden ones.
+func (g *schemaGenerator) object(t reflect.Type, overrides map[string]schema) (schema, error) {
+	properties := make(schema)
+	var required []string
+	if err := g.fields(t, properties, &required, overrides); err != nil {
+		return nil, err
+	}
+	for name := range overrides {
+		if _, ok := properties[name]; !ok {
+			return nil, fmt.Errorf("%s has no field %q to override", t, name)
+		}
+	}
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 && !optionalFields[t] {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s, nil
+}
+
+// fields adds the properties of the exported fields of t, including those of embedded structs.
+func (g *schemaGenerator) fields(t reflect.Type, properties schema, required *[]string, overrides map[string]schema) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			if err := g.fields(f.Type, properties, required, overrides); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s, ok := overrides[name]
+		if !ok {
+			var err error
+			s, err = g.ref(f.Type)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", t, f.Name, err)
+			}
+		}
+		properties[name] = s
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// param is a query or path parameter of an operation.
+type param struct {
+	name, typ, description string
+	repeated               bool
+}
+
+// response of an operation. body is a value of the response type, or a media type string for non-JSON responses.
+type response struct {
+	status      int
+	description string
+	body        any
+}
+
+// operation documents an endpoint of the API.
+type operation struct {
+	method, path, summary string
+	query                 []param
+	// A value of the type of the request body, or nil
+	request   any
+	responses []response
+}
+
+// Responses of operations that are not JSON.
+const (
+	mediaText   = "text/plain"
+	mediaEvents = "text/event-stream"
+)
+
+// Query parameters shared by several operations.
+var (
+	paramUser = param{name: "user", typ: "integer", description: "User ID"}
+	paramApp  = param{name: "app", typ: "string", description: "Application name"}
+	paramOpts = param{name: "opts", typ: "string", description: "JSON-encoded ContainerOptions, instead of a request body"}
+)
+
+// operations documents the API, in the order of the README.
+var operations = []operation{
+	{method: http.MethodPost, path: "/api/v2/containers", summary: "Create a container",
+		query:   []param{{name: "async", typ: "boolean", description: "Return a job immediately"}},
+		request: docker.ContainerOptions{},
+		responses: []response{
+			{http.StatusCreated, "The created container", docker.ContainerInfo{}},
+			{http.StatusAccepted, "The queued job, with async=true", Job{}},
+		}},
+	{method: http.MethodGet, path: "/api/v2/containers", summary: "List containers",
+		query:     []param{paramUser, paramApp},
+		responses: []response{{http.StatusOK, "Containers", []docker.ContainerInfo{}}}},
+	{method: http.MethodGet, path: "/api/v2/containers/{id}", summary: "Inspect a container",
+		responses: []response{{http.StatusOK, "The container", docker.ContainerInfo{}}}},
+	{method: http.MethodPatch, path: "/api/v2/containers/{id}", summary: "Change the deadline of a container",
+		request:   ContainerPatch{},
+		responses: []response{{http.StatusOK, "The updated container", docker.ContainerInfo{}}}},
+	{method: http.MethodDelete, path: "/api/v2/containers/{id}", summary: "Remove a container",
+		responses: []response{{http.StatusNoContent, "Removed", nil}}},
+	{method: http.MethodGet, path: "/api/v2/jobs/{id}", summary: "Get the status of an asynchronous creation",
+		responses: []response{{http.StatusOK, "The job", Job{}}}},
+
+	{method: http.MethodPost, path: "/create", summary: "Create a container (v1)",
+		query:   []param{{name: "async", typ: "boolean", description: "Return a job immediately"}},
+		request: docker.ContainerOptions{},
+		responses: []response{
+			{http.StatusOK, "The created container", docker.ContainerInfo{}},
+			{http.StatusAccepted, "The queued job, with async=true", Job{}},
+		}},
+	{method: http.MethodGet, path: "/jobs/{id}", summary: "Get the status of an asynchronous creation (v1)",
+		responses: []response{{http.StatusOK, "The job", Job{}}}},
+	{method: http.MethodPost, path: "/remove", summary: "Remove the containers matching a filter",
+		request:   RemoveRequest{},
+		responses: []response{{http.StatusOK, "Containers selected, with the result of each removal", RemoveResponse{}}}},
+	{method: http.MethodPost, path: "/restart", summary: "Restart a container in place",
+		request:   docker.ContainerOptions{},
+		responses: []response{{http.StatusOK, "The restarted container", docker.ContainerInfo{}}}},
+	{method: http.MethodPost, path: "/reset", summary: "Recreate a container, subject to a cooldown",
+		request:   docker.ContainerOptions{},
+		responses: []response{{http.StatusOK, "The new container", docker.ContainerInfo{}}}},
+	{method: http.MethodGet, path: "/logs", summary: "Stream the logs of a container",
+		query: []param{paramUser, paramApp,
+			{name: "follow", typ: "boolean", description: "Keep streaming new logs"},
+			{name: "tail", typ: "string", description: "Number of lines from the end, or \"all\""},
+			{name: "since", typ: "string", description: "Timestamp or relative duration"},
+			{name: "stdout", typ: "boolean", description: "Include standard output, default true"},
+			{name: "stderr", typ: "boolean", description: "Include standard error, default true"},
+		},
+		responses: []response{{http.StatusOK, "Logs, with stdout and stderr framed as by Docker if accepted", mediaText}}},
+	{method: http.MethodGet, path: "/exec", summary: "Run a command in a container over a WebSocket",
+		query: []param{paramUser, paramApp,
+			{name: "cmd", typ: "string", description: "Command and arguments", repeated: true},
+			{name: "tty", typ: "boolean", description: "Allocate a terminal"},
+			{name: "rows", typ: "integer", description: "Initial terminal height"},
+			{name: "cols", typ: "integer", description: "Initial terminal width"},
+			{name: "exec-user", typ: "string", description: "User to run the command as"},
+			{name: "env", typ: "string", description: "Environment variable as NAME=VALUE", repeated: true},
+		},
+		responses: []response{{http.StatusSwitchingProtocols, "WebSocket", nil}}},
+	{method: http.MethodGet, path: "/stats", summary: "Get the resource usage of running containers",
+		query:     []param{paramUser, paramApp},
+		responses: []response{{http.StatusOK, "Snapshots", []docker.ContainerStats{}}}},
+	{method: http.MethodGet, path: "/events", summary: "Stream lifecycle events of containers",
+		query: []param{paramUser, paramApp,
+			{name: "type", typ: "string", description: "Event type", repeated: true},
+		},
+		responses: []response{{http.StatusOK, "Server-Sent Events, with Event objects as data", mediaEvents}}},
+	{method: http.MethodPost, path: "/list", summary: "List containers (v1)",
+		request:   docker.ContainerOptions{},
+		responses: []response{{http.StatusOK, "Containers", []docker.ContainerInfo{}}}},
+	{method: http.MethodGet, path: "/list", summary: "List containers (v1)",
+		query:     []param{paramOpts},
+		responses: []response{{http.StatusOK, "Containers", []docker.ContainerInfo{}}}},
+	{method: http.MethodPost, path: "/inspect", summary: "Inspect a container (v1)",
+		request:   docker.ContainerOptions{},
+		responses: []response{{http.StatusOK, "The container", docker.ContainerInfo{}}}},
+	{method: http.MethodGet, path: "/inspect", summary: "Inspect a container (v1)",
+		query:     []param{paramOpts},
+		responses: []response{{http.StatusOK, "The container", docker.ContainerInfo{}}}},
+	{method: http.MethodGet, path: "/images", summary: "List catalog images",
+		responses: []response{{http.StatusOK, "Images", []docker.ImageInfo{}}}},
+	{method: http.MethodPost, path: "/images/pull", summary: "Pull catalog images",
+		responses: []response{{http.StatusOK, "Images", []docker.ImageInfo{}}}},
+	{method: http.MethodPost, path: "/images/prune", summary: "Remove stale versions of catalog images",
+		responses: []response{{http.StatusOK, "Images attempted to remove", []docker.ImageInfo{}}}},
+	{method: http.MethodPost, path: "/purge", summary: "Remove expired containers",
+		responses: []response{{http.StatusOK, "Containers attempted to remove", PurgeResponse{}}}},
+}
+
+// extraSchemas are components not referenced by operations, such as the data of events.
+var extraSchemas = []any{docker.Event{}}
+
+// generateOpenAPI builds the OpenAPI document of the API from operations.
+func generateOpenAPI() (schema, error) {
+	g := &schemaGenerator{components: make(map[string]schema), types: make(map[string]reflect.Type)}
+	errorRef, err := g.ref(reflect.TypeOf(ErrorResponse{}))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range extraSchemas {
+		if _, err := g.ref(reflect.TypeOf(v)); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make(map[string]schema)
+	for _, op := range operations {
+		o, err := g.operation(op, errorRef)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.method, op.path, err)
+		}
+		if paths[op.path] == nil {
+			paths[op.path] = make(schema)
+		}
+		paths[op.path][strings.ToLower(op.method)] = o
+	}
+
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":   pkg.Name,
+			"version": pkg.Version,
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas": g.components,
+			"securitySchemes": schema{
+				"bearer": schema{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// Authentication is optional if no API keys are configured
+		"security": []any{schema{"bearer": []any{}}, schema{}},
+	}, nil
+}
+
+func (g *schemaGenerator) operation(op operation, errorRef schema) (schema, error) {
+	var params []any
+	if strings.Contains(op.path, "{id}") {
+		params = append(params, schema{"name": "id", "in": "path", "required": true, "schema": schema{"type": "string"}})
+	}
+	for _, p := range op.query {
+		s := schema{"type": p.typ}
+		if p.repeated {
+			s = schema{"type": "array", "items": s}
+		}
+		params = append(params, schema{"name": p.name, "in": "query", "description": p.description, "schema": s})
+	}
+
+	responses := schema{
+		"default": schema{
+			"description": "Error",
+			"content":     schema{"application/json": schema{"schema": errorRef}},
+		},
+	}
+	for _, resp := range op.responses {
+		r := schema{"description": resp.description}
+		switch body := resp.body.(type) {
+		case nil:
+		case string:
+			r["content"] = schema{body: schema{"schema": schema{"type": "string"}}}
+		default:
+			s, err := g.ref(reflect.TypeOf(body))
+			if err != nil {
+				return nil, err
+			}
+			r["content"] = schema{"application/json": schema{"schema": s}}
+		}
+		responses[fmt.Sprint(resp.status)] = r
+	}
+
+	o := schema{"summary": op.summary, "responses": responses}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+	if op.request != nil {
+		s, err := g.ref(reflect.TypeOf(op.request))
+		if err != nil {
+			return nil, err
+		}
+		o["requestBody"] = schema{
+			"required": true,
+			"content":  schema{"application/json": schema{"schema": s}},
+		}
+	}
+	return o, nil
+}
+
+var openAPI = sync.OnceValues(func() ([]byte, error) {
+	doc, err := generateOpenAPI()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(doc, "", "  ")
+})
+
+// OpenAPI returns the OpenAPI 3 document of the API, generated from the types of requests and responses.
+func OpenAPI() ([]byte, error) {
+	return openAPI()
+}
+
+// Serve the OpenAPI document of the API.
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	b, err := OpenAPI()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// TestOpenAPIRoutes checks the paths and methods of operations against the routes registered by Run.
+// Every documented path must reach a handler, which must reject other methods with exactly
+// the documented ones in its Allow header, and every route must be documented.
+func TestOpenAPIRoutes(t *testing.T) {
+	s := &Server{mux: http.NewServeMux()}
+	routes := s.routes()
+	for pattern, handler := range routes {
+		s.mux.HandleFunc(pattern, handler)
+	}
+
+	methods := make(map[string][]string)
+	for _, op := range operations {
+		methods[op.path] = append(methods[op.path], op.method)
+	}
+	documented := make(map[string]bool)
+	for path, allowed := range methods {
+		req := httptest.NewRequest(http.MethodOptions, strings.ReplaceAll(path, "{id}", "abc"), nil)
+		if _, pattern := s.mux.Handler(req); pattern == "/" {
+			t.Errorf("%s: not routed", path)
+			continue
+		} else {
+			documented[pattern] = true
+		}
+
+		id := Identity{Name: "test", Scopes: []string{ScopeAdmin}}
+		req = req.WithContext(context.WithValue(req.Context(), identityKey{}, id))
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, req)
+		got := strings.Split(w.Header().Get("Allow"), ", ")
+		sort.Strings(got)
+		sort.Strings(allowed)
+		if w.Code != http.StatusMethodNotAllowed || !reflect.DeepEqual(got, allowed) {
+			t.Errorf("%s: got status %d allowing %v, documented %v", path, w.Code, got, allowed)
+		}
+	}
+	for pattern := range routes {
+		if pattern != "/" && !documented[pattern] {
+			t.Errorf("%s: not documented", pattern)
+		}
+	}
+}
+
+// TestOpenAPIQueryParams checks that the query parameters read by the handlers are the documented ones.
+func TestOpenAPIQueryParams(t *testing.T) {
+	documented := make(map[string]bool)
+	for _, op := range operations {
+		for _, p := range op.query {
+			documented[p.name] = true
+		}
+	}
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, name := range files {
+		// Terminals are served on the hostnames of containers, and are not part of the API
+		if strings.HasSuffix(name, "_test.go") || name == "terminal.go" {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			var query, key ast.Expr
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				// q.Get("name"), q.Has("name") or r.URL.Query().Get("name")
+				sel, ok := n.Fun.(*ast.SelectorExpr)
+				if ok && (sel.Sel.Name == "Get" || sel.Sel.Name == "Has") && len(n.Args) == 1 {
+					query, key = sel.X, n.Args[0]
+				}
+			case *ast.IndexExpr:
+				// q["name"]
+				query, key = n.X, n.Index
+			}
+			if query == nil || !isQuery(query) {
+				return true
+			}
+			if lit, ok := key.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				name, _ := strconv.Unquote(lit.Value)
+				read[name] = true
+			}
+			return true
+		})
+	}
+
+	for name := range read {
+		if !documented[name] {
+			t.Errorf("query parameter %q is read but not documented", name)
+		}
+	}
+	for name := range documented {
+		if !read[name] {
+			t.Errorf("query parameter %q is documented but not read", name)
+		}
+	}
+}
+
+// isQuery reports whether x is the query of a request, either q or r.URL.Query().
+func isQuery(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name == "q"
+	case *ast.CallExpr:
+		sel, ok := x.Fun.(*ast.SelectorExpr)
+		return ok && sel.Sel.Name == "Query"
+	}
+	return false
+}
+
+// TestOpenAPIRoundTrip checks that sample values encode to JSON matching their schemas,
+// and decode back to the same values.
+func TestOpenAPIRoundTrip(t *testing.T) {
+	doc, err := generateOpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	components := doc["components"].(schema)["schemas"].(map[string]schema)
+
+	info := docker.ContainerInfo{
+		Name:     "podzol_1000_web",
+		ID:       "3f9a0c",
+		Hostname: "abc123",
+		Deadline: time.Unix(1700000000, 0),
+		User:     1000,
+		App:      "web",
+		Port:     80,
+		Network:  "podzol_staging",
+		Egress: &docker.EgressPolicy{
+			Mode:  docker.EgressAllowlist,
+			Allow: []docker.EgressRule{{CIDR: "10.0.0.53/32", Port: 53, Proto: "udp"}},
+		},
+		Profile:   "default",
+		State:     "running",
+		Readiness: docker.ReadinessReady,
+		Terminal:  true,
+	}
+	samples := []any{
+		docker.ContainerOptions{User: 1000, Token: "secret", AppName: "web", Hostname: "abc123", Image: "nginx", Lifetime: 90 * time.Minute},
+		docker.ContainerOptions{User: 1000, AppName: "web"},
+		info,
+		docker.ContainerInfo{Name: "podzol_1000_web", ID: "3f9a0c", Deadline: time.Unix(0, 0)},
+		PurgeResponse{
+			Containers: []docker.ContainerInfo{info},
+			Errors:     []docker.ContainerActionError{{Action: "remove", Container: info, Err: errors.New("conflict")}},
+		},
+		PurgeResponse{Containers: []docker.ContainerInfo{}, Errors: []docker.ContainerActionError{}},
+		ErrorResponse{Error: "container not found", Code: CodeNotFound},
+	}
+	for _, sample := range samples {
+		typ := reflect.TypeOf(sample)
+		t.Run(typ.Name(), func(t *testing.T) {
+			b, err := json.Marshal(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v any
+			if err := json.Unmarshal(b, &v); err != nil {
+				t.Fatal(err)
+			}
+			s, ok := components[typ.Name()]
+			if !ok {
+				t.Fatalf("no schema for %s", typ)
+			}
+			if err := validate(components, s, v, "$"); err != nil {
+				t.Errorf("%s does not match its schema: %v", b, err)
+			}
+
+			decoded := reflect.New(typ)
+			if err := json.Unmarshal(b, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			again, err := json.Marshal(decoded.Elem().Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, again) {
+				t.Errorf("round trip changed %s to %s", b, again)
+			}
+		})
+	}
+}
+
+// validate checks a value decoded from JSON against a schema of the document.
+// It supports what generateOpenAPI produces. Objects may only have the properties of their schema.
+func validate(components map[string]schema, s schema, v any, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		c, ok := components[strings.TrimPrefix(ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown reference %s", path, ref)
+		}
+		return validate(components, c, v, path)
+	}
+	if v == nil && s["nullable"] == true {
+		return nil
+	}
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			if err := validate(components, sub.(schema), v, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if one, ok := s["oneOf"].([]any); ok {
+		matched := 0
+		for _, sub := range one {
+			if validate(components, sub.(schema), v, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: %v matches %d schemas of oneOf", path, v, matched)
+		}
+		return nil
+	}
+
+	switch s["type"] {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", path, v)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			return fmt.Errorf("%s: %v is not an integer", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: %v is not a number", path, v)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", path, v)
+		}
+		if s["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", path, v)
+		}
+		for i, item := range items {
+			if err := validate(components, s["items"].(schema), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", path, v)
+		}
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		properties, _ := s["properties"].(schema)
+		additional, _ := s["additionalProperties"].(schema)
+		for name, value := range obj {
+			p, ok := properties[name].(schema)
+			if !ok {
+				p = additional
+			}
+			if p == nil {
+				return fmt.Errorf("%s: undocumented property %q", path, name)
+			}
+			if err := validate(components, p, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unsupported schema %v", path, s)
+	}
+	return nil
+}
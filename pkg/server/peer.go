@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// unixPrefix marks listen addresses that are Unix socket paths.
+const unixPrefix = "unix:"
+
+// Peer is the process on the other end of a Unix socket connection.
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+func (p Peer) String() string {
+	return fmt.Sprintf("pid=%d,uid=%d,gid=%d", p.PID, p.UID, p.GID)
+}
+
+type peerKey struct{}
+
+// PeerFrom returns the peer credentials of a request received on a Unix socket.
+func PeerFrom(ctx context.Context) (Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(Peer)
+	return p, ok
+}
+
+// connContext records the peer credentials of Unix socket connections in their context.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if peer, ok := peerCredentials(conn); ok {
+		return context.WithValue(ctx, peerKey{}, peer)
+	}
+	return ctx
+}
+
+// peerAddr reports the credentials of Unix socket peers as the remote address of requests,
+// which is otherwise empty, so that they appear in logs and the audit log.
+func peerAddr(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if peer, ok := PeerFrom(r.Context()); ok {
+			r.RemoteAddr = unixPrefix + peer.String()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// UnixPeer is an entry of the "unix-peers" configuration, keyed by name.
+// A peer matches if its user ID or its primary group ID is listed.
+type UnixPeer struct {
+	UIDs   []uint32 `mapstructure:"uids"`
+	GIDs   []uint32 `mapstructure:"gids"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+func (u UnixPeer) matches(p Peer) bool {
+	for _, uid := range u.UIDs {
+		if uid == p.UID {
+			return true
+		}
+	}
+	for _, gid := range u.GIDs {
+		if gid == p.GID {
+			return true
+		}
+	}
+	return false
+}
+
+func loadUnixPeers(v *viper.Viper) (map[string]UnixPeer, error) {
+	peers := make(map[string]UnixPeer)
+	if err := v.UnmarshalKey("unix-peers", &peers); err != nil {
+		return nil, err
+	}
+	for name, peer := range peers {
+		for _, scope := range peer.Scopes {
+			if scope != ScopeUser && scope != ScopeAdmin {
+				return nil, fmt.Errorf("unix peer %q: invalid scope %q", name, scope)
+			}
+		}
+	}
+	return peers, nil
+}
+
+// peerIdentity returns the identity of the first matching entry of "unix-peers", by name.
+func (s *Server) peerIdentity(p Peer) (Identity, bool) {
+	names := make([]string, 0, len(s.unixPeers))
+	for name := range s.unixPeers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s.unixPeers[name].matches(p) {
+			return Identity{Name: name, Scopes: s.unixPeers[name].Scopes}, true
+		}
+	}
+	return Identity{}, false
+}
+
+// listenUnix listens on a Unix socket, replacing a stale one, with the configured mode and group.
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// Left over by a previous run, as sockets are not removed on close
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the socket when the listener is closed after a handoff, as the new process still uses it
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(path, s.listenMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if s.listenGroup != "" {
+		gid, err := lookupGroup(s.listenGroup)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("listen-group: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// lookupGroup resolves a group name or numeric ID.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// parseMode parses a file mode in octal, such as "0660".
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the credentials of the process on the other end of a Unix socket connection.
+func peerCredentials(conn net.Conn) (Peer, bool) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, false
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return Peer{}, false
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return Peer{}, false
+	}
+	return Peer{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, true
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/logging"
+)
+
+// RequestIDHeader carries the ID of an API request, which is included in all log records of the request.
+// A valid ID supplied by the client is kept, otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// statusWriter captures the response status for the request log.
+// It passes through flushing for streaming responses and hijacking for WebSockets.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// annotations are attributes added to the request log by handlers.
+type annotations struct {
+	mu   sync.Mutex
+	args []any
+}
+
+type annotationsKey struct{}
+
+// annotate adds attributes to the log record of the request.
+func annotate(ctx context.Context, args ...any) {
+	a, ok := ctx.Value(annotationsKey{}).(*annotations)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.args = append(a.args, args...)
+}
+
+// annotateOptions adds the user and app of a request to its log record.
+func annotateOptions(ctx context.Context, opts docker.ContainerOptions) {
+	annotate(ctx, "user", opts.User, "app", opts.AppName)
+}
+
+// logRequests wraps a handler to assign request IDs and log every request once it has been served.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		log := slog.With("request_id", id)
+		a := &annotations{}
+		ctx := logging.WithLogger(r.Context(), log)
+		ctx = context.WithValue(ctx, annotationsKey{}, a)
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		a.mu.Lock()
+		args := append([]any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		}, a.args...)
+		a.mu.Unlock()
+		log.Log(ctx, level, "api request", args...)
+	})
+}
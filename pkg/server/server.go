@@ -5,23 +5,74 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg/audit"
 	"github.com/ustclug/podzol/pkg/docker"
+	"github.com/ustclug/podzol/pkg/metrics"
+	"github.com/ustclug/podzol/pkg/webhook"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
 	docker *docker.Client
 	mux    *http.ServeMux
+	jobs   *jobQueue
 
-	listenAddr string
-	httpAddr   string
+	idempotency   *idempotencyStore
+	resetCooldown *cooldown
+	apiKeys       map[string]APIKey
+	unixPeers     map[string]UnixPeer
+	auditLog      *audit.Log
+
+	// Cancelled when the server stops, for background work.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Cancelled when shutdown begins, to end long-lived streams.
+	drainCtx context.Context
+	drain    context.CancelFunc
+
+	listeners       map[string]net.Listener
+	inherited       map[string]net.Listener
+	readyPipe       *os.File
+	handedOff       bool
+	api             *http.Server
+	proxy           *HTTPServer
+	ssh             *SSHServer
+	metricsServer   *http.Server
+	grpc            *grpc.Server
+	shutdownTimeout time.Duration
+
+	listenAddr  string
+	listenMode  os.FileMode
+	listenGroup string
+	httpAddr    string
+
+	sshAddr       string
+	sshHostKey    string
+	sshForwarding bool
+	sshLoginTime  time.Duration
+	sshStartups   int
+
+	metricsAddr string
+	metrics     *prometheus.Registry
+
+	grpcAddr string
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Machine-readable error code, always set by API v2
+	Code string `json:"code,omitempty"`
 }
 
 func NewServer(v *viper.Viper) (*Server, error) {
@@ -30,133 +81,431 @@ func NewServer(v *viper.Viper) (*Server, error) {
 		return nil, err
 	}
 
-	return &Server{
-		docker: dockerClient,
-		mux:    http.NewServeMux(),
+	apiKeys, err := loadAPIKeys(v)
+	if err != nil {
+		return nil, err
+	}
+	unixPeers, err := loadUnixPeers(v)
+	if err != nil {
+		return nil, err
+	}
+	listenMode, err := parseMode(v.GetString("listen-mode"))
+	if err != nil {
+		return nil, fmt.Errorf("listen-mode: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	drainCtx, drain := context.WithCancel(context.Background())
+	s := &Server{
+		docker:    dockerClient,
+		mux:       http.NewServeMux(),
+		apiKeys:   apiKeys,
+		unixPeers: unixPeers,
+		ctx:       ctx,
+		cancel:    cancel,
+		drainCtx:  drainCtx,
+		drain:     drain,
+
+		listeners:       make(map[string]net.Listener),
+		inherited:       make(map[string]net.Listener),
+		shutdownTimeout: v.GetDuration("shutdown-timeout"),
+
+		listenAddr:  v.GetString("listen-addr"),
+		listenMode:  listenMode,
+		listenGroup: v.GetString("listen-group"),
+		httpAddr:    v.GetString("http-addr"),
+
+		sshAddr:       v.GetString("ssh.listen-addr"),
+		sshHostKey:    v.GetString("ssh.host-key"),
+		sshForwarding: v.GetBool("ssh.allow-port-forwarding"),
+		sshLoginTime:  v.GetDuration("ssh.login-timeout"),
+		sshStartups:   v.GetInt("ssh.max-startups"),
 
-		listenAddr: v.GetString("listen-addr"),
-		httpAddr:   v.GetString("http-addr"),
-	}, nil
+		metricsAddr: v.GetString("metrics-addr"),
+		grpcAddr:    v.GetString("grpc-addr"),
+	}
+	if path := v.GetString("audit-log"); path != "" {
+		s.auditLog, err = audit.Open(path, []byte(v.GetString("audit-key")))
+		if err != nil {
+			return nil, fmt.Errorf("audit log: %w", err)
+		}
+	}
+	targets, err := webhook.LoadTargets(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) > 0 {
+		// Subscribe before Docker events are watched, so that no event is missed
+		events, _ := dockerClient.Subscribe(100)
+		go webhook.NewDispatcher(targets).Run(ctx, events)
+	}
+	s.resetCooldown = newCooldown(v.GetDuration("reset-cooldown"))
+	s.idempotency = newIdempotencyStore(v.GetDuration("idempotency-retention"))
+	s.jobs = newJobQueue(s, v.GetInt("create-queue"), v.GetDuration("job-retention"))
+	s.jobs.run(ctx, v.GetInt("create-workers"))
+
+	s.metrics = prometheus.NewRegistry()
+	metrics.Register(s.metrics)
+	s.metrics.MustRegister(
+		metrics.NewContainerCollector(dockerClient.RunningByApp),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "podzol_create_queue_depth",
+			Help: "Asynchronous creations waiting for a worker.",
+		}, func() float64 { return float64(s.jobs.len()) }),
+	)
+
+	s.api = &http.Server{
+		Handler:     peerAddr(logRequests(s)),
+		ConnContext: connContext,
+	}
+	s.proxy = s.HTTPServer()
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.HandlerFor(s.metrics, promhttp.HandlerOpts{}))
+	s.metricsServer = &http.Server{Handler: metricsMux}
+	s.grpc = s.newGRPCServer()
+	return s, nil
+}
+
+// errorStatus maps errors from the docker package to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case errors.Is(err, docker.ErrContainerExists):
+		return http.StatusConflict
+	case errors.Is(err, docker.ErrInvalidDeadline):
+		return http.StatusBadRequest
+	case errors.Is(err, docker.ErrReadinessFailed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, docker.ErrNoStateFile):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func HandleDefault(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNotFound)
-	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: "not found"})
+	writeError(w, http.StatusNotFound, CodeNotFound, "not found")
 }
 
 // Create a container.
+// If the "async" query parameter is true, a Job is returned immediately with status 202.
 func (s *Server) HandleCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 
-	var opts docker.ContainerOptions
-	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	s.createContainer(w, r, "")
+}
+
+// RemoveRequest is the request body of /remove.
+// Requests with both user and app, as sent by older clients, remove a single container.
+type RemoveRequest struct {
+	docker.ContainerFilter
+	// Only select the containers, without removing them
+	DryRun bool `json:"dry_run"`
+}
+
+// RemoveResponse is the response of /remove.
+type RemoveResponse struct {
+	DryRun bool `json:"dry_run"`
+	// One result for each container selected, with the error if it could not be removed
+	Results []docker.ContainerActionError `json:"results"`
+}
+
+// Remove the containers matching a filter.
+func (s *Server) HandleRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req RemoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
 	ctx := r.Context()
-	info, err := s.docker.Create(ctx, opts)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s := fmt.Sprintf("failed to create container: %v", err)
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: s})
+	opts := docker.ContainerOptions{User: req.User, AppName: req.App}
+	annotateOptions(ctx, opts)
+	annotate(ctx, "dry_run", req.DryRun)
+	if req.User != 0 && req.App != "" && len(req.Labels) == 0 {
+		s.removeOne(w, r, opts, req.DryRun)
+		return
+	}
+	containers, err := s.docker.RemoveAll(ctx, req.ContainerFilter, req.DryRun)
+	if !req.DryRun {
+		s.recordEach(ctx, s.auditEntry(r, audit.ActionRemove, opts), containers, err)
+	}
+	if len(containers) == 0 && err != nil {
+		writeDockerError(w, "remove containers", err)
 		return
 	}
 
+	failed := failedContainers(err)
+	resp := RemoveResponse{
+		DryRun:  req.DryRun,
+		Results: make([]docker.ContainerActionError, 0, len(containers)),
+	}
+	for _, info := range containers {
+		resp.Results = append(resp.Results, docker.ContainerActionError{
+			Action:    "remove",
+			Container: info,
+			Err:       failed[info.Name],
+		})
+	}
+
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(info)
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// Remove a container.
-func (s *Server) HandleRemove(w http.ResponseWriter, r *http.Request) {
-	var opts docker.ContainerOptions
-	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
-		return
-	}
-
+// removeOne removes the container of a user and an app by its name, without listing the others.
+// Containers whose label cannot be parsed are removed as well.
+func (s *Server) removeOne(w http.ResponseWriter, r *http.Request, opts docker.ContainerOptions, dryRun bool) {
 	ctx := r.Context()
-	if err := s.docker.Remove(ctx, opts); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s := fmt.Sprintf("failed to remove container: %v", err)
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: s})
+	info, err := s.docker.Inspect(ctx, opts)
+	if errors.Is(err, docker.ErrInvalidLabel) {
+		info, err = docker.ContainerInfo{Name: s.docker.ContainerName(opts), User: opts.User, App: opts.AppName}, nil
+	}
+	if err != nil {
+		writeDockerError(w, "remove container", err)
 		return
 	}
+	if !dryRun {
+		err := s.docker.Remove(ctx, opts)
+		s.recordContainer(ctx, s.auditEntry(r, audit.ActionRemove, opts), info, err)
+		if err != nil {
+			writeDockerError(w, "remove container", err)
+			return
+		}
+	}
 
+	resp := RemoveResponse{
+		DryRun:  dryRun,
+		Results: []docker.ContainerActionError{{Action: "remove", Container: info}},
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("{}"))
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// List containers.
-// Filters of type docker.ContainerOptions may be passed as either the "opts" query parameter or as request body. In either case, the filters are JSON-encoded.
-func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
-	var opts docker.ContainerOptions
+// readOptions decodes docker.ContainerOptions from either the "opts" query parameter (GET) or the request body (POST).
+// It writes an error response and returns false on failure.
+func readOptions(w http.ResponseWriter, r *http.Request, opts *docker.ContainerOptions) bool {
 	switch r.Method {
 	case http.MethodGet:
 		optsJSON := r.URL.Query().Get("opts")
 		if optsJSON != "" {
-			if err := json.Unmarshal([]byte(optsJSON), &opts); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
-				return
+			if err := json.Unmarshal([]byte(optsJSON), opts); err != nil {
+				writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return false
 			}
 		}
 	case http.MethodPost:
 		defer r.Body.Close()
-		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
-			return
+		if err := json.NewDecoder(r.Body).Decode(opts); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return false
 		}
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return false
+	}
+	return true
+}
+
+// Restart a container in place.
+func (s *Server) HandleRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var opts docker.ContainerOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
 	ctx := r.Context()
-	containers, err := s.docker.List(ctx, opts)
+	annotateOptions(ctx, opts)
+	info, err := s.docker.Restart(ctx, opts)
+	s.recordContainer(ctx, s.auditEntry(r, audit.ActionRestart, opts), info, err)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s := fmt.Sprintf("failed to list containers: %v", err)
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: s})
+		writeDockerError(w, "restart container", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(containers)
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+// Reset a container by recreating it, subject to a per-user cooldown.
+func (s *Server) HandleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var opts docker.ContainerOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if wait := s.resetCooldown.acquire(opts.User); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+		msg := fmt.Sprintf("reset is on cooldown, retry in %s", wait.Round(time.Second))
+		writeError(w, http.StatusTooManyRequests, CodeCooldown, msg)
+		return
+	}
+
+	ctx := r.Context()
+	annotateOptions(ctx, opts)
+	info, err := s.docker.Reset(ctx, opts)
+	s.recordContainer(ctx, s.auditEntry(r, audit.ActionReset, opts), info, err)
+	if err != nil {
+		s.resetCooldown.release(opts.User)
+		writeDockerError(w, "reset container", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+// List containers.
+// Filters of type docker.ContainerOptions may be passed as either the "opts" query parameter or as request body. In either case, the filters are JSON-encoded.
+func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
+	var opts docker.ContainerOptions
+	if !readOptions(w, r, &opts) {
+		return
+	}
+	s.listContainers(w, r, opts)
+}
+
+// Inspect a container.
+// Options are passed in the same way as HandleList.
+func (s *Server) HandleInspect(w http.ResponseWriter, r *http.Request) {
+	var opts docker.ContainerOptions
+	if !readOptions(w, r, &opts) {
+		return
+	}
+	s.inspectContainer(w, r, opts)
 }
 
 type PurgeResponse struct {
+	// Containers that have been attempted to remove
 	Containers []docker.ContainerInfo `json:"containers"`
-	Errors     error                  `json:"errors"`
+	// Containers that could not be removed
+	Errors []docker.ContainerActionError `json:"errors"`
 }
 
 // Purge containers.
 func (s *Server) HandlePurge(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 
 	ctx := r.Context()
 	containers, err := s.docker.Purge(ctx)
+	s.recordEach(ctx, s.auditEntry(r, audit.ActionPurge, docker.ContainerOptions{}), containers, err)
+	if containers == nil && err != nil {
+		writeDockerError(w, "purge containers", err)
+		return
+	}
 	resp := PurgeResponse{
 		Containers: containers,
-		Errors:     errors.Unwrap(err),
+		Errors:     actionErrors(err),
 	}
 
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// List catalog images.
+func (s *Server) HandleImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(s.docker.Images(r.Context()))
+}
+
+// Pull catalog images.
+func (s *Server) HandleImagesPull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	images := s.docker.PullImages(r.Context())
+	for _, info := range images {
+		e := s.auditEntry(r, audit.ActionImagesPull, docker.ContainerOptions{})
+		e.Detail = info.Image
+		var err error
+		if info.Error != "" {
+			err = errors.New(info.Error)
+		}
+		s.record(r.Context(), e, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(images)
+}
+
+// Remove stale versions of catalog images.
+// Errors are reported per image.
+func (s *Server) HandleImagesPrune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	images, err := s.docker.PruneImages(r.Context())
+	for _, info := range images {
+		e := s.auditEntry(r, audit.ActionImagesPrune, docker.ContainerOptions{})
+		e.Detail = info.Image
+		var err error
+		if info.Error != "" {
+			err = errors.New(info.Error)
+		}
+		s.record(r.Context(), e, err)
+	}
+	if images == nil && err != nil {
+		s.record(r.Context(), s.auditEntry(r, audit.ActionImagesPrune, docker.ContainerOptions{}), err)
+		writeDockerError(w, "prune images", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(images)
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/openapi.json" {
+		// Public, so that tools can fetch it without an API key
+		HandleOpenAPI(w, r)
+		return
+	}
+	id, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "invalid or missing API key")
+		return
+	}
+	annotate(r.Context(), "identity", id.Name)
+	r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
 	s.mux.ServeHTTP(w, r)
 }
 
@@ -165,18 +514,92 @@ func (s *Server) DockerInfo(ctx context.Context) (types.Info, error) {
 }
 
 func (s *Server) DockerInit(ctx context.Context) error {
-	return nil
+	return s.docker.Init(ctx)
+}
+
+// routes returns the handlers of the API by mux pattern.
+// The endpoints are documented in operations, see openapi.go.
+func (s *Server) routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/":             HandleDefault,
+		"/create":       s.idempotent(s.HandleCreate),
+		"/remove":       s.HandleRemove,
+		"/restart":      s.HandleRestart,
+		"/reset":        s.HandleReset,
+		"/list":         s.HandleList,
+		"/inspect":      s.HandleInspect,
+		"/logs":         s.HandleLogs,
+		"/stats":        s.HandleStats,
+		"/events":       s.HandleEvents,
+		"/exec":         s.require(ScopeAdmin, s.HandleExec),
+		"/purge":        s.HandlePurge,
+		"/jobs/":        s.HandleJob,
+		"/images":       s.HandleImages,
+		"/images/pull":  s.HandleImagesPull,
+		"/images/prune": s.HandleImagesPrune,
+		apiV2 + "/":     s.HandleV2,
+	}
 }
 
 func (s *Server) Run() error {
-	s.mux.HandleFunc("/", HandleDefault)
-	s.mux.HandleFunc("/create", s.HandleCreate)
-	s.mux.HandleFunc("/remove", s.HandleRemove)
-	s.mux.HandleFunc("/list", s.HandleList)
-	s.mux.HandleFunc("/purge", s.HandlePurge)
-	return http.ListenAndServe(s.listenAddr, s)
+	for pattern, handler := range s.routes() {
+		s.mux.HandleFunc(pattern, handler)
+	}
+	return serveHTTP(s.api, s.listeners[listenerAPI])
+}
+
+// serveHTTP serves on the listener opened by Listen, returning nil after Shutdown.
+func serveHTTP(srv *http.Server, l net.Listener) error {
+	if l == nil {
+		return errors.New("not listening")
+	}
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func (s *Server) RunHTTP() error {
-	return s.HTTPServer().ListenAndServe()
+	l := s.listeners[listenerProxy]
+	if l == nil {
+		return errors.New("not listening")
+	}
+	return s.proxy.Serve(l)
+}
+
+// MetricsEnabled reports whether the metrics endpoint is configured.
+func (s *Server) MetricsEnabled() bool {
+	return s.metricsAddr != ""
+}
+
+// RunMetrics serves Prometheus metrics at /metrics on the metrics address.
+func (s *Server) RunMetrics() error {
+	return serveHTTP(s.metricsServer, s.listeners[listenerMetrics])
+}
+
+// SSHEnabled reports whether the SSH gateway is configured.
+func (s *Server) SSHEnabled() bool {
+	return s.sshAddr != ""
+}
+
+func (s *Server) RunSSH() error {
+	l := s.listeners[listenerSSH]
+	if l == nil || s.ssh == nil {
+		return errors.New("not listening")
+	}
+	return s.ssh.Serve(l)
+}
+
+// GRPCEnabled reports whether the gRPC API is configured.
+func (s *Server) GRPCEnabled() bool {
+	return s.grpcAddr != ""
+}
+
+// RunGRPC serves the gRPC management API on the gRPC address.
+func (s *Server) RunGRPC() error {
+	l := s.listeners[listenerGRPC]
+	if l == nil {
+		return errors.New("not listening")
+	}
+	return s.grpc.Serve(l)
 }
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ShutdownTimeout is how long Shutdown should wait for connections to finish.
+func (s *Server) ShutdownTimeout() time.Duration {
+	return s.shutdownTimeout
+}
+
+// streamContext returns a context for long-lived responses, which is also cancelled when shutdown begins,
+// so that open streams do not hold up a graceful shutdown.
+func (s *Server) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.drainCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Shutdown stops accepting connections, and waits for API requests, gRPC calls, proxied connections,
+// SSH sessions and queued and running creations to finish until ctx is done. Then it stops background work,
+// saves the state and closes the audit log.
+// Connections still open when ctx is done are closed. WebSocket connections of the API, used by exec,
+// are not waited for.
+//
+// After a handoff, the new process watches Docker and owns the state file, so this one stops
+// doing either, and waits for proxied connections and SSH sessions of players without a deadline.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.drain()
+	sessionCtx := ctx
+	if s.handedOff {
+		s.docker.Release()
+		sessionCtx = context.WithoutCancel(ctx)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	shutdown := func(ctx context.Context, name string, f func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(ctx); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}()
+	}
+	shutdown(ctx, listenerAPI, s.api.Shutdown)
+	shutdown(sessionCtx, listenerProxy, s.proxy.Shutdown)
+	shutdown(ctx, listenerMetrics, s.metricsServer.Shutdown)
+	if s.ssh != nil {
+		shutdown(sessionCtx, listenerSSH, s.ssh.Shutdown)
+	}
+	shutdown(ctx, listenerGRPC, s.shutdownGRPC)
+	shutdown(ctx, "jobs", s.jobs.shutdown)
+	wg.Wait()
+
+	s.cancel()
+	if !s.handedOff {
+		if err := s.docker.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("save state: %w", err))
+		}
+	}
+	if err := s.auditLog.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("audit log: %w", err))
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Warn("unclean shutdown", "error", err)
+	}
+	return err
+}
+
+// shutdownGRPC stops the gRPC server gracefully, cancelling the remaining calls when ctx is done.
+func (s *Server) shutdownGRPC(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
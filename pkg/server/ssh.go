@@ -0,0 +1,432 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ustclug/podzol/pkg/docker"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHServer is a gateway into containers. The SSH username selects the container by hostname,
+// and the password is the token the container was created with.
+type SSHServer struct {
+	s      *Server
+	config *ssh.ServerConfig
+
+	allowForwarding bool
+	conns           connTracker
+
+	// loginTimeout and startups limit unauthenticated connections,
+	// like LoginGraceTime and MaxStartups in OpenSSH.
+	loginTimeout time.Duration
+	startups     chan struct{}
+}
+
+var errAccessDenied = errors.New("access denied")
+
+// loadHostKey reads the host key from path, generating one if the file does not exist.
+func loadHostKey(path string) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(key)
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// Create an SSHServer from a Server.
+func (s *Server) SSHServer() (*SSHServer, error) {
+	signer, err := loadHostKey(s.sshHostKey)
+	if err != nil {
+		return nil, fmt.Errorf("ssh host key: %w", err)
+	}
+	g := &SSHServer{s: s, allowForwarding: s.sshForwarding, loginTimeout: s.sshLoginTime}
+	if s.sshStartups > 0 {
+		g.startups = make(chan struct{}, s.sshStartups)
+	}
+	g.config = &ssh.ServerConfig{
+		PasswordCallback: g.authenticate,
+		ServerVersion:    "SSH-2.0-podzol",
+	}
+	g.config.AddHostKey(signer)
+	return g, nil
+}
+
+// authenticate checks the password against the token of the container.
+// The ID of the container is recorded, so that a replaced container does not inherit the session.
+func (g *SSHServer) authenticate(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	info, _, ok := g.s.docker.SSHTarget(g.s.ctx, meta.User())
+	if !ok || !info.CheckToken(string(password)) {
+		return nil, errAccessDenied
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"container": info.ID}}, nil
+}
+
+func (g *SSHServer) Handle(conn net.Conn) {
+	defer conn.Close()
+	start := time.Now()
+	log := slog.With("remote", conn.RemoteAddr().String())
+
+	sconn, chans, reqs, err := g.login(conn)
+	if err != nil {
+		log.Info("ssh handshake failed", "error", err)
+		return
+	}
+	defer sconn.Close()
+
+	info, config, ok := g.s.docker.SSHTarget(g.s.ctx, sconn.User())
+	if !ok || info.ID != sconn.Permissions.Extensions["container"] {
+		return
+	}
+	log = log.With("hostname", sconn.User(), "container", info.Name, "user", info.User, "app", info.App, "mode", config.Mode)
+	log.Info("ssh login")
+	defer func() { log.Info("ssh connection closed", "duration", time.Since(start)) }()
+
+	if config.Mode == docker.SSHForward {
+		g.forward(sconn, chans, reqs, info, config)
+		return
+	}
+
+	// Global requests are only used for remote port forwarding, which is not supported
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "session":
+			go g.session(newCh, info, config)
+		case "direct-tcpip":
+			if !g.allowForwarding {
+				_ = newCh.Reject(ssh.Prohibited, "port forwarding is disabled")
+				continue
+			}
+			go g.directTCPIP(newCh, info)
+		default:
+			_ = newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+var errTooManyStartups = errors.New("too many unauthenticated connections")
+
+// login runs the handshake and authentication of a connection, within the login timeout.
+func (g *SSHServer) login(conn net.Conn) (*ssh.ServerConn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	if g.startups != nil {
+		select {
+		case g.startups <- struct{}{}:
+			defer func() { <-g.startups }()
+		default:
+			return nil, nil, nil, errTooManyStartups
+		}
+	}
+	if g.loginTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(g.loginTimeout))
+	}
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, g.config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return sconn, chans, reqs, nil
+}
+
+// Payloads of channel requests, see RFC 4254.
+type (
+	ptyRequest struct {
+		Term          string
+		Columns, Rows uint32
+		Width, Height uint32
+		Modes         string
+	}
+	windowChange struct {
+		Columns, Rows uint32
+		Width, Height uint32
+	}
+	envRequest struct {
+		Name, Value string
+	}
+	execRequest struct {
+		Command string
+	}
+	exitStatus struct {
+		Status uint32
+	}
+	directTCPIPRequest struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+)
+
+// acceptEnv reports whether a client may set the environment variable, like AcceptEnv in OpenSSH.
+func acceptEnv(name string) bool {
+	return name == "LANG" || len(name) > 3 && name[:3] == "LC_"
+}
+
+// session runs a command in the container for a session channel in exec mode.
+func (g *SSHServer) session(newCh ssh.NewChannel, info docker.ContainerInfo, config docker.SSHConfig) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	opts := docker.ContainerOptions{User: info.User, AppName: info.App}
+	execOpts := docker.ExecOptions{User: config.User}
+	var session *docker.ExecSession
+	done := make(chan struct{})
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			var pty ptyRequest
+			if session == nil && ssh.Unmarshal(req.Payload, &pty) == nil {
+				execOpts.Tty = true
+				execOpts.Rows, execOpts.Cols = uint(pty.Rows), uint(pty.Columns)
+				execOpts.Env = append(execOpts.Env, "TERM="+pty.Term)
+				ok = true
+			}
+		case "env":
+			var env envRequest
+			if session == nil && ssh.Unmarshal(req.Payload, &env) == nil && acceptEnv(env.Name) {
+				execOpts.Env = append(execOpts.Env, env.Name+"="+env.Value)
+				ok = true
+			}
+		case "window-change":
+			var size windowChange
+			if session != nil && ssh.Unmarshal(req.Payload, &size) == nil {
+				ok = session.Resize(g.s.ctx, uint(size.Rows), uint(size.Columns)) == nil
+			}
+		case "shell", "exec":
+			if session != nil {
+				break
+			}
+			var cmd execRequest
+			if req.Type == "exec" && ssh.Unmarshal(req.Payload, &cmd) != nil {
+				break
+			}
+			var env []string
+			execOpts.Cmd, env = config.CommandFor(cmd.Command)
+			execOpts.Env = append(execOpts.Env, env...)
+			session, err = g.s.docker.Exec(g.s.ctx, opts, execOpts)
+			if err != nil {
+				fmt.Fprintf(ch.Stderr(), "failed to exec: %v\r\n", err)
+				break
+			}
+			ok = true
+			go g.relay(ch, session, done)
+		}
+		if req.WantReply {
+			_ = req.Reply(ok, nil)
+		}
+	}
+	if session != nil {
+		session.Close()
+		<-done
+	}
+}
+
+// relay copies between the channel and the command, and reports the exit status when the command exits.
+func (g *SSHServer) relay(ch ssh.Channel, session *docker.ExecSession, done chan<- struct{}) {
+	defer close(done)
+	go func() {
+		_, _ = io.Copy(session, ch)
+		_ = session.CloseWrite()
+	}()
+	_ = session.Output(ch, ch.Stderr())
+	code, err := session.ExitCode(g.s.ctx)
+	if err == nil {
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{uint32(code)}))
+	}
+	ch.Close()
+}
+
+// directTCPIP forwards a local port forwarding channel to a port of the container.
+// Only the container itself may be the destination.
+func (g *SSHServer) directTCPIP(newCh ssh.NewChannel, info docker.ContainerInfo) {
+	var req directTCPIPRequest
+	if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, "malformed request")
+		return
+	}
+	switch req.Host {
+	case "localhost", "127.0.0.1", "::1", info.Hostname:
+	default:
+		_ = newCh.Reject(ssh.Prohibited, "only the container may be connected to")
+		return
+	}
+	addr, err := g.s.docker.ContainerAddr(info, int(req.Port))
+	if err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer upstream.Close()
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(upstream, ch)
+		_ = upstream.(*net.TCPConn).CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(ch, upstream)
+		_ = ch.CloseWrite()
+	}()
+	wg.Wait()
+}
+
+// forward relays all channels of the connection to the SSH server in the container.
+func (g *SSHServer) forward(sconn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, info docker.ContainerInfo, config docker.SSHConfig) {
+	go ssh.DiscardRequests(reqs)
+
+	addr, err := g.s.docker.ContainerAddr(info, config.ForwardPort())
+	if err != nil {
+		return
+	}
+	clientConfig := &ssh.ClientConfig{
+		User: config.User,
+		// The container is reached on a private network, and its host key is not known in advance
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+	if config.Password != "" {
+		clientConfig.Auth = []ssh.AuthMethod{ssh.Password(config.Password)}
+	}
+	upstream, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		slog.Warn("ssh forward failed", "container", info.Name, "upstream", addr, "error", err)
+		return
+	}
+	defer upstream.Close()
+	go func() {
+		// Tear down the client connection if the container goes away
+		_ = upstream.Wait()
+		sconn.Close()
+	}()
+
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "session":
+		case "direct-tcpip":
+			if !g.allowForwarding {
+				_ = newCh.Reject(ssh.Prohibited, "port forwarding is disabled")
+				continue
+			}
+		default:
+			_ = newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		go bridgeChannel(newCh, upstream)
+	}
+}
+
+// bridgeChannel opens the same channel on the upstream connection and relays data and requests.
+func bridgeChannel(newCh ssh.NewChannel, upstream ssh.Conn) {
+	upCh, upReqs, err := upstream.OpenChannel(newCh.ChannelType(), newCh.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			_ = newCh.Reject(openErr.Reason, openErr.Message)
+		} else {
+			_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	defer upCh.Close()
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	go func() {
+		forwardRequests(reqs, upCh)
+		// The client closed the channel
+		upCh.Close()
+	}()
+	go func() {
+		_, _ = io.Copy(upCh, ch)
+		_ = upCh.CloseWrite()
+	}()
+
+	// Wait for the output and all requests, so that the exit status arrives before the channel is closed
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(ch, upCh)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(ch.Stderr(), upCh.Stderr())
+	}()
+	go func() {
+		defer wg.Done()
+		forwardRequests(upReqs, ch)
+	}()
+	wg.Wait()
+}
+
+// forwardRequests sends channel requests on to the other side, relaying the replies.
+func forwardRequests(reqs <-chan *ssh.Request, ch ssh.Channel) {
+	for req := range reqs {
+		ok, err := ch.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			_ = req.Reply(ok && err == nil, nil)
+		}
+	}
+}
+
+// Serve handles connections accepted from l. It returns nil after Shutdown.
+func (g *SSHServer) Serve(l net.Listener) error {
+	return g.conns.serve(l, g.Handle)
+}
+
+// Shutdown stops accepting connections and waits for open sessions to finish.
+// When ctx is done, the remaining connections are closed.
+func (g *SSHServer) Shutdown(ctx context.Context) error {
+	return g.conns.shutdown(ctx)
+}
+
+func (g *SSHServer) ListenAndServe() error {
+	l, err := net.Listen("tcp", g.s.sshAddr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// Get a snapshot of the resource usage of running containers.
+// Optional query parameters: user, app.
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := r.URL.Query()
+	var opts docker.ContainerOptions
+	if user := q.Get("user"); user != "" {
+		var err error
+		opts.User, err = strconv.Atoi(user)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	opts.AppName = q.Get("app")
+
+	ctx := r.Context()
+	annotateOptions(ctx, opts)
+	stats, err := s.docker.Stats(ctx, opts)
+	if err != nil {
+		writeDockerError(w, "get stats", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ustclug/podzol/pkg/docker"
+	"golang.org/x/net/websocket"
+)
+
+// terminalCookie carries the token of the container in the browser, once entered.
+const terminalCookie = "podzol-token"
+
+// terminalFS holds the page, and xterm.js under assets, fetched by make terminal-assets.
+// The files are listed so that the build fails without them.
+//
+//go:embed terminal/terminal.html terminal/assets/xterm.css terminal/assets/xterm.js terminal/assets/xterm-addon-fit.js
+var terminalFS embed.FS
+
+var terminalPage = template.Must(template.ParseFS(terminalFS, "terminal/terminal.html"))
+
+// connListener is a net.Listener for connections handed off by the proxy.
+type connListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// handoffConn replays what the proxy has read, and signals when the connection is closed.
+type handoffConn struct {
+	net.Conn
+	r      io.Reader
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *handoffConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *handoffConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// handoff serves the connection from the terminal listener, and waits until it is closed.
+func (l *connListener) handoff(conn net.Conn, r io.Reader) {
+	c := &handoffConn{Conn: conn, r: r, closed: make(chan struct{})}
+	select {
+	case l.conns <- c:
+		<-c.closed
+	case <-l.done:
+	}
+}
+
+// terminalContainer returns the container serving a web terminal on the requested host.
+func (s *Server) terminalContainer(r *http.Request) (docker.ContainerInfo, bool) {
+	hostname := strings.SplitN(r.Host, ".", 2)[0]
+	return s.docker.Terminal(r.Context(), hostname)
+}
+
+// terminalAuthorized checks the token cookie of the request against the container.
+func terminalAuthorized(r *http.Request, info docker.ContainerInfo) bool {
+	cookie, err := r.Cookie(terminalCookie)
+	return err == nil && info.CheckToken(cookie.Value)
+}
+
+// Serve the terminal page, or ask for the token.
+// A valid token posted by the form is moved into a cookie.
+func (s *Server) handleTerminalPage(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.terminalContainer(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Hostname   string
+		Authorized bool
+		Failed     bool
+	}{Hostname: info.Hostname}
+	if r.Method == http.MethodPost {
+		token := r.PostFormValue("token")
+		if info.CheckToken(token) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     terminalCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		data.Failed = true
+	}
+	data.Authorized = terminalAuthorized(r, info)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !data.Authorized {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	_ = terminalPage.Execute(w, data)
+}
+
+// Relay a terminal session in the container over a WebSocket, using the /exec protocol.
+func (s *Server) handleTerminalSocket(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.terminalContainer(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !terminalAuthorized(r, info) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	rows, _ := parseUint(q.Get("rows"))
+	cols, _ := parseUint(q.Get("cols"))
+	ctx := r.Context()
+	ws := websocket.Server{
+		Handshake: sameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			// Only start the terminal once the handshake has succeeded
+			session, err := s.docker.ExecTerminal(ctx, info, rows, cols)
+			if err != nil {
+				ws.PayloadType = websocket.BinaryFrame
+				sendExit(ws, ExecExit{Error: fmt.Sprintf("failed to start terminal: %v", err)})
+				ws.Close()
+				return
+			}
+			defer session.Close()
+			serveExec(ctx, ws, session)
+		},
+	}
+	ws.ServeHTTP(w, r)
+}
+
+// terminalHandler serves web terminals on hostnames of applications with a terminal enabled.
+func (s *Server) terminalHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleTerminalPage)
+	mux.HandleFunc("/terminal/ws", s.handleTerminalSocket)
+	mux.Handle("/terminal/assets/", http.FileServer(http.FS(terminalFS)))
+	return mux
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/ustclug/podzol/pkg/audit"
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// apiV2 is the path prefix of the resource-oriented API.
+const apiV2 = "/api/v2"
+
+// Machine-readable error codes, in ErrorResponse.Code.
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeContainerExists     = "container_exists"
+	CodeInvalidDeadline     = "invalid_deadline"
+	CodeNotReady            = "not_ready"
+	CodeIdempotencyMismatch = "idempotency_mismatch"
+	CodeConflict            = "conflict"
+	CodeQueueFull           = "queue_full"
+	CodeCooldown            = "cooldown"
+	CodeNotSupported        = "not_supported"
+	CodeInternal            = "internal"
+)
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: msg, Code: code})
+}
+
+// writeDockerError writes an error from the docker package, prefixed with what failed.
+func writeDockerError(w http.ResponseWriter, what string, err error) {
+	code := CodeInternal
+	status := errorStatus(err)
+	switch {
+	case errors.Is(err, docker.ErrContainerExists):
+		code = CodeContainerExists
+	case errors.Is(err, docker.ErrInvalidDeadline):
+		code = CodeInvalidDeadline
+	case errors.Is(err, docker.ErrReadinessFailed):
+		code = CodeNotReady
+	case errors.Is(err, docker.ErrNoStateFile):
+		code = CodeNotSupported
+	case errdefs.IsNotFound(err):
+		code = CodeNotFound
+	}
+	writeError(w, status, code, fmt.Sprintf("failed to %s: %v", what, err))
+}
+
+// methodNotAllowed responds with 405 and the methods allowed on the resource.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+}
+
+// ContainerPatch is the request body of PATCH /api/v2/containers/{id}.
+type ContainerPatch struct {
+	// New deadline as a Unix timestamp, like ContainerInfo.Deadline.
+	Deadline int64 `json:"deadline"`
+}
+
+// HandleV2 routes requests of API v2:
+//
+//	POST   /api/v2/containers       create a container
+//	GET    /api/v2/containers       list containers, filtered by the user and app query parameters
+//	GET    /api/v2/containers/{id}  inspect a container
+//	PATCH  /api/v2/containers/{id}  change the deadline of a container
+//	DELETE /api/v2/containers/{id}  remove a container
+//	GET    /api/v2/jobs/{id}        get the status of an asynchronous creation
+//
+// Containers are identified by their name or Docker ID.
+func (s *Server) HandleV2(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiV2)
+	if path == "/containers" {
+		switch r.Method {
+		case http.MethodGet:
+			s.v2ListContainers(w, r)
+		case http.MethodPost:
+			s.idempotent(s.v2CreateContainer)(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+	if id, ok := strings.CutPrefix(path, "/containers/"); ok && id != "" && !strings.Contains(id, "/") {
+		switch r.Method {
+		case http.MethodGet:
+			s.v2GetContainer(w, r, id)
+		case http.MethodPatch:
+			s.v2PatchContainer(w, r, id)
+		case http.MethodDelete:
+			s.v2DeleteContainer(w, r, id)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+		}
+		return
+	}
+	if id, ok := strings.CutPrefix(path, "/jobs/"); ok && id != "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.getJob(w, id)
+		return
+	}
+	writeError(w, http.StatusNotFound, CodeNotFound, "not found")
+}
+
+func (s *Server) v2CreateContainer(w http.ResponseWriter, r *http.Request) {
+	s.createContainer(w, r, apiV2)
+}
+
+// createContainer creates the container of the request body, or queues its creation with ?async=true,
+// for the API under prefix. API v2 responds with 201 Created and the location of the container,
+// v1 with 200 OK.
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request, prefix string) {
+	var opts docker.ContainerOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	ctx := r.Context()
+	annotateOptions(ctx, opts)
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		job, err := s.jobs.submit(opts, s.auditEntry(r, audit.ActionCreate, opts))
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, CodeQueueFull, fmt.Sprintf("failed to create container: %v", err))
+			return
+		}
+		annotate(ctx, "job", job.ID)
+		w.Header().Set("Location", prefix+"/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	info, err := s.docker.Create(ctx, opts)
+	if err == nil {
+		// The container is kept for inspection, and replaced by the next create if the policy allows
+		err = docker.CheckReadiness(info)
+	}
+	s.recordContainer(ctx, s.auditEntry(r, audit.ActionCreate, opts), info, err)
+	if err != nil {
+		writeDockerError(w, "create container", err)
+		return
+	}
+	annotate(ctx, "container", info.Name)
+	status := http.StatusOK
+	if prefix == apiV2 {
+		w.Header().Set("Location", apiV2+"/containers/"+url.PathEscape(info.Name))
+		status = http.StatusCreated
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+func (s *Server) v2ListContainers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var opts docker.ContainerOptions
+	if user := q.Get("user"); user != "" {
+		var err error
+		opts.User, err = strconv.Atoi(user)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	opts.AppName = q.Get("app")
+	s.listContainers(w, r, opts)
+}
+
+// listContainers responds with the containers selected by opts.
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request, opts docker.ContainerOptions) {
+	ctx := r.Context()
+	annotateOptions(ctx, opts)
+	containers, err := s.docker.List(ctx, opts)
+	if err != nil {
+		writeDockerError(w, "list containers", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(containers)
+}
+
+// resolve finds the options selecting the container with the given ID.
+// It writes an error response and returns false on failure.
+func (s *Server) resolve(w http.ResponseWriter, r *http.Request, id string) (docker.ContainerOptions, bool) {
+	opts, err := s.docker.Resolve(r.Context(), id)
+	if err != nil {
+		writeDockerError(w, "find container", err)
+		return opts, false
+	}
+	annotateOptions(r.Context(), opts)
+	return opts, true
+}
+
+func (s *Server) v2GetContainer(w http.ResponseWriter, r *http.Request, id string) {
+	opts, ok := s.resolve(w, r, id)
+	if !ok {
+		return
+	}
+	s.inspectContainer(w, r, opts)
+}
+
+// inspectContainer responds with the container selected by opts.
+func (s *Server) inspectContainer(w http.ResponseWriter, r *http.Request, opts docker.ContainerOptions) {
+	ctx := r.Context()
+	annotateOptions(ctx, opts)
+	info, err := s.docker.Inspect(ctx, opts)
+	if err != nil {
+		writeDockerError(w, "inspect container", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+func (s *Server) v2PatchContainer(w http.ResponseWriter, r *http.Request, id string) {
+	var patch ContainerPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if patch.Deadline == 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "deadline is required")
+		return
+	}
+	opts, ok := s.resolve(w, r, id)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	deadline := time.Unix(patch.Deadline, 0)
+	info, err := s.docker.Extend(ctx, opts, deadline)
+	e := s.auditEntry(r, audit.ActionExtend, opts)
+	e.Detail = "deadline " + deadline.UTC().Format(time.RFC3339)
+	s.recordContainer(ctx, e, info, err)
+	if err != nil {
+		writeDockerError(w, "extend container", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+func (s *Server) v2DeleteContainer(w http.ResponseWriter, r *http.Request, id string) {
+	opts, ok := s.resolve(w, r, id)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	err := s.docker.Remove(ctx, opts)
+	s.record(ctx, s.auditEntry(r, audit.ActionRemove, opts), err)
+	if err != nil {
+		writeDockerError(w, "remove container", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
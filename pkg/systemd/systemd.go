@@ -0,0 +1,113 @@
+// Package systemd implements socket activation and service notification,
+// without depending on libsystemd.
+//
+// See sd_listen_fds(3) and sd_notify(3).
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// States sent with Notify.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Listeners returns the sockets passed by systemd, keyed by their FileDescriptorName=.
+// It returns nil if the process has not been socket-activated.
+// The environment variables are unset, so that child processes do not take the sockets as their own.
+func Listeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener)
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s: %w", name, err)
+		}
+		if _, ok := listeners[name]; ok {
+			l.Close()
+			return nil, fmt.Errorf("more than one socket named %s", name)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// Notify sends the state to the service manager.
+// It does nothing if the process has not been started by systemd with notification enabled.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns the interval at which the service manager expects watchdog
+// notifications, or 0 if the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog sends watchdog notifications at half the expected interval until ctx is done.
+// It returns immediately if the watchdog is not enabled.
+func RunWatchdog(ctx context.Context) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = Notify(Watchdog)
+		}
+	}
+}
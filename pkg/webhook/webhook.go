@@ -0,0 +1,256 @@
+// Package webhook delivers container lifecycle events to HTTP endpoints.
+//
+// Each event is POSTed as JSON, signed with HMAC-SHA256 over the request body
+// using the secret of the target. Failed deliveries are retried with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/ustclug/podzol/pkg"
+	"github.com/ustclug/podzol/pkg/docker"
+)
+
+// Headers of webhook requests.
+const (
+	EventHeader     = "X-Podzol-Event"
+	DeliveryHeader  = "X-Podzol-Delivery"
+	SignatureHeader = "X-Podzol-Signature"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	maxBackoff         = 5 * time.Minute
+	queueSize          = 1000
+)
+
+// Target is an entry of the "webhooks" configuration.
+type Target struct {
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret"`
+	// Events to deliver. Defaults to all.
+	Events []string `mapstructure:"events"`
+	// Timeout of a single attempt. Defaults to 10s.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Attempts before giving up on an event. Defaults to 5.
+	MaxAttempts int `mapstructure:"max-attempts"`
+	// Delay before the first retry, doubled after each attempt. Defaults to 1s.
+	Backoff time.Duration `mapstructure:"backoff"`
+}
+
+func (t *Target) validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	for _, e := range t.Events {
+		if !isEvent(e) {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	if t.Timeout < 0 || t.MaxAttempts < 0 || t.Backoff < 0 {
+		return errors.New("timeout, max-attempts and backoff must not be negative")
+	}
+	if t.Timeout == 0 {
+		t.Timeout = defaultTimeout
+	}
+	if t.MaxAttempts == 0 {
+		t.MaxAttempts = defaultMaxAttempts
+	}
+	if t.Backoff == 0 {
+		t.Backoff = defaultBackoff
+	}
+	return nil
+}
+
+func isEvent(e string) bool {
+	for _, known := range docker.Events {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// wants reports whether the target subscribes to the event type.
+func (t *Target) wants(typ string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTargets reads and validates the "webhooks" configuration.
+func LoadTargets(v *viper.Viper) ([]Target, error) {
+	var targets []Target
+	if err := v.UnmarshalKey("webhooks", &targets); err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		if err := targets[i].validate(); err != nil {
+			return nil, fmt.Errorf("webhook %q: %w", targets[i].URL, err)
+		}
+	}
+	return targets, nil
+}
+
+// Payload is the body of a webhook request.
+type Payload struct {
+	// Unique ID of the event, identical across retries
+	ID string `json:"id"`
+	docker.Event
+}
+
+// Sign returns the value of the signature header for the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header of a webhook request against its body.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewPayload wraps an event for delivery.
+func NewPayload(e docker.Event) Payload {
+	return Payload{ID: newID(), Event: e}
+}
+
+// Deliver sends the payload to the target once. Any status other than 2xx is an error.
+func Deliver(ctx context.Context, t Target, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", strings.ToLower(pkg.Name)+"/"+pkg.Version)
+	req.Header.Set(EventHeader, p.Type)
+	req.Header.Set(DeliveryHeader, p.ID)
+	if t.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(t.Secret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// deliverWithRetry sends the payload until it succeeds, attempts run out or ctx is done.
+func deliverWithRetry(ctx context.Context, t Target, p Payload) error {
+	backoff := t.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = Deliver(ctx, t, p)
+		if err == nil || attempt >= t.MaxAttempts {
+			return err
+		}
+		slog.Debug("webhook delivery failed, retrying",
+			"url", t.URL, "event", p.Type, "delivery", p.ID, "attempt", attempt, "retry", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// Dispatcher delivers events to webhook targets.
+// Each target has its own queue, so that a slow target does not hold up the others.
+type Dispatcher struct {
+	targets []Target
+}
+
+func NewDispatcher(targets []Target) *Dispatcher {
+	return &Dispatcher{targets: targets}
+}
+
+// Run delivers events until the channel is closed or ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, events <-chan docker.Event) {
+	queues := make([]chan Payload, len(d.targets))
+	for i, t := range d.targets {
+		queues[i] = make(chan Payload, queueSize)
+		go d.deliverLoop(ctx, t, queues[i])
+	}
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			p := NewPayload(e)
+			for i, t := range d.targets {
+				if !t.wants(e.Type) {
+					continue
+				}
+				select {
+				case queues[i] <- p:
+				default:
+					slog.Warn("webhook queue full, dropping event", "url", t.URL, "event", e.Type, "container", e.Container.Name)
+				}
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) deliverLoop(ctx context.Context, t Target, queue <-chan Payload) {
+	for p := range queue {
+		if err := deliverWithRetry(ctx, t, p); err != nil {
+			slog.Warn("webhook delivery failed",
+				"url", t.URL, "event", p.Type, "delivery", p.ID, "container", p.Container.Name, "error", err)
+		}
+	}
+}